@@ -0,0 +1,132 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PendingCommentResponse struct {
+	ID        uint      `json:"id"`
+	ParentID  uint      `json:"parent_id"`
+	Author    string    `json:"author"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// needsApproval reports whether a comment by author on receiver's board has
+// to be approved before anyone else can see it, either because the board
+// requires it or because a content filter flagged the comment. Owners never
+// need to approve their own comments.
+func needsApproval(receiver, author GitHubUser, flagged bool) bool {
+	return author.ID != receiver.ID && (receiver.RequireApproval || flagged)
+}
+
+func getBoardSettings(c *gin.Context) {
+	receiver, ok := boardOwner(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(200, gin.H{"require_approval": receiver.RequireApproval})
+}
+
+func updateBoardSettings(c *gin.Context) {
+	receiver, ok := boardOwner(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		RequireApproval bool `json:"require_approval"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := store.SetRequireApproval(receiver.ID, req.RequireApproval); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to update settings"})
+		return
+	}
+
+	c.JSON(200, gin.H{"require_approval": req.RequireApproval})
+}
+
+func listPendingComments(c *gin.Context) {
+	receiver, ok := boardOwner(c)
+	if !ok {
+		return
+	}
+
+	comments, err := store.ListCommentViews(receiver.ID, 0)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to get comments"})
+		return
+	}
+
+	responses := make([]PendingCommentResponse, 0)
+	for _, comment := range comments {
+		if !comment.Pending {
+			continue
+		}
+		responses = append(responses, PendingCommentResponse{
+			ID:        comment.ID,
+			ParentID:  comment.ParentID,
+			Author:    comment.Author,
+			Content:   comment.Content,
+			CreatedAt: comment.CreatedAt,
+		})
+	}
+	c.JSON(200, responses)
+}
+
+// pendingComment resolves :commentID to a comment awaiting approval on a
+// board the caller owns. ok is false once an error response has been
+// written.
+func pendingComment(c *gin.Context) (GitHubUser, Comment, bool) {
+	receiver, comment, ok := boardComment(c)
+	if !ok {
+		return GitHubUser{}, Comment{}, false
+	}
+
+	if !comment.Pending {
+		c.JSON(400, gin.H{"error": "Comment is not awaiting approval"})
+		return GitHubUser{}, Comment{}, false
+	}
+	return receiver, comment, true
+}
+
+func approveComment(c *gin.Context) {
+	receiver, comment, ok := pendingComment(c)
+	if !ok {
+		return
+	}
+
+	if err := store.SetCommentPending(comment.ID, false); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to approve comment"})
+		return
+	}
+
+	recordModeration(c, comment, moderationApprove)
+	touchBoard(receiver.ID)
+
+	c.JSON(200, gin.H{"message": "Comment approved"})
+}
+
+func rejectComment(c *gin.Context) {
+	receiver, comment, ok := pendingComment(c)
+	if !ok {
+		return
+	}
+
+	if err := store.DeleteComment(comment.ID); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to reject comment"})
+		return
+	}
+
+	recordModeration(c, comment, moderationReject)
+	touchBoard(receiver.ID)
+
+	c.JSON(200, gin.H{"message": "Comment rejected"})
+}
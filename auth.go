@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Principal is the authenticated caller of a request, identified by exactly
+// one of Token or Session. A browser session grants every scope.
+type Principal struct {
+	User    GitHubUser
+	Token   *APIToken
+	Session *UserSession
+}
+
+func (p *Principal) Can(scope string) bool {
+	return p.Token == nil || p.Token.HasScope(scope)
+}
+
+const principalKey = "principal"
+
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// OptionalAuth resolves the caller if there is one. Anonymous requests pass
+// through, but a bearer token that was sent must be valid and carry scope.
+func OptionalAuth(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, err := authenticate(c)
+		if err != nil {
+			abortUnauthorized(c)
+			return
+		}
+		if principal != nil && !principal.Can(scope) {
+			abortMissingScope(c, scope)
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireAuth rejects anonymous requests with 401 and tokens without scope
+// with 403.
+func RequireAuth(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, err := authenticate(c)
+		if err != nil || principal == nil {
+			abortUnauthorized(c)
+			return
+		}
+		if !principal.Can(scope) {
+			abortMissingScope(c, scope)
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireSession is RequireAuth for routes that must not be reachable with
+// an API token, such as managing the tokens themselves.
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, err := authenticate(c)
+		if err != nil || principal == nil {
+			abortUnauthorized(c)
+			return
+		}
+		if principal.Session == nil {
+			c.AbortWithStatusJSON(403, gin.H{"error": "This endpoint requires a browser session"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireAdmin follows RequireAuth on operator-only routes and rejects
+// callers whose login is not listed in config.Admins.
+func RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !isAdmin(currentUser(c)) {
+			c.AbortWithStatusJSON(403, gin.H{"error": "This endpoint is for administrators only"})
+			return
+		}
+		c.Next()
+	}
+}
+
+func isAdmin(user GitHubUser) bool {
+	for _, login := range config.Admins {
+		if strings.EqualFold(login, user.GitHubLogin) {
+			return true
+		}
+	}
+	return false
+}
+
+func abortUnauthorized(c *gin.Context) {
+	c.AbortWithStatusJSON(401, gin.H{"error": "Unauthorized"})
+}
+
+func abortMissingScope(c *gin.Context, scope string) {
+	c.AbortWithStatusJSON(403, gin.H{"error": fmt.Sprintf("Token is missing the %q scope", scope)})
+}
+
+// currentPrincipal returns the caller resolved by the auth middleware, or
+// nil for anonymous requests.
+func currentPrincipal(c *gin.Context) *Principal {
+	principal, _ := c.Get(principalKey)
+	p, _ := principal.(*Principal)
+	return p
+}
+
+// currentUser returns the caller on routes guarded by RequireAuth or
+// RequireSession.
+func currentUser(c *gin.Context) GitHubUser {
+	return currentPrincipal(c).User
+}
+
+// authenticate resolves the caller from an Authorization: Bearer token or
+// else the session cookie and stores it in the context. It returns a nil
+// principal for anonymous requests and an error for a bad bearer token.
+func authenticate(c *gin.Context) (*Principal, error) {
+	var principal *Principal
+	if header := c.GetHeader("Authorization"); header != "" {
+		secret, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok {
+			return nil, errInvalidCredentials
+		}
+		p, err := tokenPrincipal(strings.TrimSpace(secret))
+		if err != nil {
+			return nil, err
+		}
+		principal = p
+	} else {
+		principal = sessionPrincipal(c)
+	}
+
+	if principal != nil {
+		c.Set(principalKey, principal)
+	}
+	return principal, nil
+}
+
+func tokenPrincipal(secret string) (*Principal, error) {
+	token, err := store.FindAPIToken(hashSecret(secret))
+	if err != nil {
+		return nil, errInvalidCredentials
+	}
+
+	now := time.Now()
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > apiTokenTouchInterval {
+		if err := store.TouchAPIToken(token.ID, now); err != nil {
+			fmt.Println("Error updating token last use:", err)
+		}
+		token.LastUsedAt = &now
+	}
+
+	user, err := store.FindUser(token.UserID)
+	if err != nil {
+		return nil, errInvalidCredentials
+	}
+	return &Principal{User: user, Token: &token}, nil
+}
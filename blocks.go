@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Block stops BlockedID from commenting on or reacting to comments on
+// ReceiverID's board.
+type Block struct {
+	ID         uint `gorm:"primary_key"`
+	ReceiverID uint
+	BlockedID  uint
+	CreatedAt  time.Time
+}
+
+type BlockResponse struct {
+	Login     string    `json:"login"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// checkNotBlocked writes a 403 and returns false if the caller is blocked on
+// the receiver's board.
+func checkNotBlocked(c *gin.Context, receiverID uint) bool {
+	blocked, err := store.IsBlocked(receiverID, currentUser(c).ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to check block list"})
+		return false
+	}
+	if blocked {
+		c.JSON(403, gin.H{"error": "You are blocked on this profile"})
+		return false
+	}
+	return true
+}
+
+func listBlocks(c *gin.Context) {
+	receiver, ok := boardOwner(c)
+	if !ok {
+		return
+	}
+
+	blocks, err := store.ListBlocks(receiver.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to get block list"})
+		return
+	}
+
+	responses := make([]BlockResponse, 0, len(blocks))
+	for _, block := range blocks {
+		user, err := store.FindUser(block.BlockedID)
+		if err != nil {
+			continue
+		}
+		responses = append(responses, BlockResponse{
+			Login:     user.GitHubLogin,
+			CreatedAt: block.CreatedAt,
+		})
+	}
+	c.JSON(200, responses)
+}
+
+func blockUser(c *gin.Context) {
+	receiver, ok := boardOwner(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Login        string `json:"login"`
+		HideComments bool   `json:"hide_comments"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	blocked, err := store.FindUserByLogin(req.Login)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "GitHub user not found"})
+		return
+	}
+
+	if blocked.ID == receiver.ID {
+		c.JSON(400, gin.H{"error": "You can't block yourself"})
+		return
+	}
+
+	if err := store.CreateBlock(&Block{ReceiverID: receiver.ID, BlockedID: blocked.ID}); err != nil {
+		if errors.Is(err, ErrBlockExists) {
+			c.JSON(400, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(500, gin.H{"error": "Failed to block user"})
+		}
+		return
+	}
+
+	if req.HideComments {
+		hideAuthorComments(c, receiver, blocked)
+	}
+
+	c.JSON(200, gin.H{"message": "User blocked"})
+}
+
+// hideAuthorComments hides every visible comment author has left on
+// receiver's board, recording each one in the moderation log.
+func hideAuthorComments(c *gin.Context, receiver, author GitHubUser) {
+	comments, err := store.ListCommentViews(receiver.ID, 0)
+	if err != nil {
+		fmt.Println("Error listing comments to hide:", err)
+		return
+	}
+
+	hidden := 0
+	for _, comment := range comments {
+		if comment.AuthorID != author.ID || comment.Hidden {
+			continue
+		}
+		if err := store.SetCommentHidden(comment.ID, true); err != nil {
+			fmt.Println("Error hiding comment:", err)
+			continue
+		}
+		recordModeration(c, Comment{
+			ID:         comment.ID,
+			ReceiverID: receiver.ID,
+			AuthorID:   author.ID,
+			Content:    comment.Content,
+		}, moderationHide)
+		hidden++
+	}
+
+	if hidden > 0 {
+		touchBoard(receiver.ID)
+	}
+}
+
+func unblockUser(c *gin.Context) {
+	receiver, ok := boardOwner(c)
+	if !ok {
+		return
+	}
+
+	blocked, err := store.FindUserByLogin(c.Param("login"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "GitHub user not found"})
+		return
+	}
+
+	if err := store.DeleteBlock(receiver.ID, blocked.ID); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			c.JSON(404, gin.H{"error": "User is not blocked"})
+		} else {
+			c.JSON(500, gin.H{"error": "Failed to unblock user"})
+		}
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "User unblocked"})
+}
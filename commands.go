@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// runCommand executes an administrative subcommand against the configured
+// database instead of starting the HTTP server.
+func runCommand(cfg DatabaseConfig, args []string) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	s, err := connectStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	switch args[0] {
+	case "migrate":
+		return runMigrate(s, args[1:])
+	case "recount":
+		repaired, err := s.RecountReactions()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Repaired reaction counts on %d comments\n", repaired)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+func runMigrate(s Store, args []string) error {
+	m, ok := s.(schemaMigrator)
+	if !ok {
+		return errors.New("the configured driver has no schema to migrate")
+	}
+
+	action := "status"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	switch action {
+	case "status":
+	case "up":
+		if err := s.Migrate(); err != nil {
+			return err
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid step count %q", args[1])
+			}
+			steps = n
+		}
+		if err := m.MigrateDown(steps); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("usage: migrate [status|up|down [steps]]")
+	}
+
+	statuses, err := m.MigrationStatus()
+	if err != nil {
+		return err
+	}
+	for _, status := range statuses {
+		applied := "pending"
+		if status.AppliedAt != nil {
+			applied = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%04d %-30s %s\n", status.Version, status.Name, applied)
+	}
+	return nil
+}
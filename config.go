@@ -0,0 +1,377 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	Port                  string                `json:"port"`
+	OriginURL             string                `json:"origin_url"`
+	SessionSecret         string                `json:"session_secret"`
+	SessionTTL            Duration              `json:"session_ttl"`
+	SessionCookieSecure   bool                  `json:"session_cookie_secure"`
+	SessionCookieSameSite string                `json:"session_cookie_samesite"`
+	GitHubClientID        string                `json:"github_client_id"`
+	GitHubClientSecret    string                `json:"github_client_secret"`
+	ShutdownTimeout       Duration              `json:"shutdown_timeout"`
+	SVGCacheTTL           Duration              `json:"svg_cache_ttl"`
+	SVGMaxAge             Duration              `json:"svg_max_age"`
+	CommentsMaxAge        Duration              `json:"comments_max_age"`
+	ReplyMaxDepth         int                   `json:"reply_max_depth"`
+	AllowVisitorReplies   bool                  `json:"allow_visitor_replies"`
+	ContentFilters        []ContentFilterConfig `json:"content_filters"`
+	ReportHideThreshold   int                   `json:"report_hide_threshold"`
+	Admins                []string              `json:"admins"`
+	TrustedProxies        []string              `json:"trusted_proxies"`
+	RateLimitBackend      string                `json:"rate_limit_backend"`
+	CommentRateLimit      RateLimit             `json:"comment_rate_limit"`
+	CommentIPRateLimit    RateLimit             `json:"comment_ip_rate_limit"`
+	ReactionRateLimit     RateLimit             `json:"reaction_rate_limit"`
+	ReactionIPRateLimit   RateLimit             `json:"reaction_ip_rate_limit"`
+	Database              DatabaseConfig        `json:"database"`
+}
+
+type DatabaseConfig struct {
+	Driver          string   `json:"driver"`
+	Host            string   `json:"host"`
+	Port            string   `json:"port"`
+	User            string   `json:"user"`
+	Password        string   `json:"password"`
+	Name            string   `json:"name"`
+	SSLMode         string   `json:"sslmode"`
+	ConnectAttempts int      `json:"connect_attempts"`
+	ConnectBackoff  Duration `json:"connect_backoff"`
+}
+
+// Duration is a time.Duration that reads and writes as a string such as
+// "15s" in config files.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// RateLimit allows Requests every Per, refilled evenly over Per. It reads
+// and writes as a string such as "10/1m"; "0" means no limit.
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+}
+
+func parseRateLimit(s string) (RateLimit, error) {
+	if s == "0" || s == "" {
+		return RateLimit{}, nil
+	}
+
+	requests, per, ok := strings.Cut(s, "/")
+	if !ok {
+		return RateLimit{}, fmt.Errorf("rate limit %q is not of the form requests/duration", s)
+	}
+	n, err := strconv.Atoi(requests)
+	if err != nil || n < 1 {
+		return RateLimit{}, fmt.Errorf("rate limit %q must allow at least 1 request", s)
+	}
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return RateLimit{}, fmt.Errorf("rate limit %q must have a positive duration", s)
+	}
+	return RateLimit{Requests: n, Per: d}, nil
+}
+
+func (r RateLimit) String() string {
+	if r.Requests == 0 {
+		return "0"
+	}
+	return fmt.Sprintf("%d/%s", r.Requests, r.Per)
+}
+
+func (r RateLimit) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+func (r *RateLimit) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := parseRateLimit(s)
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
+
+func defaultConfig() Config {
+	return Config{
+		Port:                  "8080",
+		SessionTTL:            Duration(30 * 24 * time.Hour),
+		SessionCookieSameSite: "lax",
+		ShutdownTimeout:       Duration(15 * time.Second),
+		SVGCacheTTL:           Duration(10 * time.Minute),
+		SVGMaxAge:             Duration(time.Minute),
+		ReplyMaxDepth:         1,
+		ReportHideThreshold:   3,
+		RateLimitBackend:      "memory",
+		CommentRateLimit:      RateLimit{Requests: 10, Per: time.Minute},
+		CommentIPRateLimit:    RateLimit{Requests: 30, Per: time.Minute},
+		ReactionRateLimit:     RateLimit{Requests: 60, Per: time.Minute},
+		ReactionIPRateLimit:   RateLimit{Requests: 180, Per: time.Minute},
+		Database: DatabaseConfig{
+			Driver:          "mysql",
+			SSLMode:         "disable",
+			ConnectAttempts: 5,
+			ConnectBackoff:  Duration(time.Second),
+		},
+	}
+}
+
+// loadConfig builds the effective configuration from the defaults, the
+// optional JSON config file and finally the environment, in that order.
+func loadConfig(path string) (Config, error) {
+	cfg := defaultConfig()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, fmt.Errorf("reading config file: %w", err)
+		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("parsing config file: %w", err)
+		}
+	}
+
+	for name, field := range cfg.envFields() {
+		if value, ok := os.LookupEnv(name); ok {
+			if err := setField(field, value); err != nil {
+				return cfg, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+
+	return cfg, nil
+}
+
+func setField(field any, value string) error {
+	switch f := field.(type) {
+	case *string:
+		*f = value
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*f = n
+	case *RateLimit:
+		r, err := parseRateLimit(value)
+		if err != nil {
+			return err
+		}
+		*f = r
+	case *[]string:
+		*f = nil
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*f = append(*f, item)
+			}
+		}
+	case *bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*f = b
+	case *Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*f = Duration(d)
+	default:
+		return fmt.Errorf("unsupported config field type %T", field)
+	}
+	return nil
+}
+
+func (cfg *Config) envFields() map[string]any {
+	return map[string]any{
+		"PORT":                    &cfg.Port,
+		"ORIGIN_URL":              &cfg.OriginURL,
+		"SESSION_SECRET":          &cfg.SessionSecret,
+		"SESSION_TTL":             &cfg.SessionTTL,
+		"SESSION_COOKIE_SECURE":   &cfg.SessionCookieSecure,
+		"SESSION_COOKIE_SAMESITE": &cfg.SessionCookieSameSite,
+		"GITHUB_CLIENT_ID":        &cfg.GitHubClientID,
+		"GITHUB_CLIENT_SECRET":    &cfg.GitHubClientSecret,
+		"SHUTDOWN_TIMEOUT":        &cfg.ShutdownTimeout,
+		"SVG_CACHE_TTL":           &cfg.SVGCacheTTL,
+		"SVG_MAX_AGE":             &cfg.SVGMaxAge,
+		"COMMENTS_MAX_AGE":        &cfg.CommentsMaxAge,
+		"REPLY_MAX_DEPTH":         &cfg.ReplyMaxDepth,
+		"ALLOW_VISITOR_REPLIES":   &cfg.AllowVisitorReplies,
+		"REPORT_HIDE_THRESHOLD":   &cfg.ReportHideThreshold,
+		"ADMINS":                  &cfg.Admins,
+		"TRUSTED_PROXIES":         &cfg.TrustedProxies,
+		"RATE_LIMIT_BACKEND":      &cfg.RateLimitBackend,
+		"COMMENT_RATE_LIMIT":      &cfg.CommentRateLimit,
+		"COMMENT_IP_RATE_LIMIT":   &cfg.CommentIPRateLimit,
+		"REACTION_RATE_LIMIT":     &cfg.ReactionRateLimit,
+		"REACTION_IP_RATE_LIMIT":  &cfg.ReactionIPRateLimit,
+		"DB_DRIVER":               &cfg.Database.Driver,
+		"DB_HOST":                 &cfg.Database.Host,
+		"DB_PORT":                 &cfg.Database.Port,
+		"DB_USER":                 &cfg.Database.User,
+		"DB_PASSWORD":             &cfg.Database.Password,
+		"DB_DATABASE":             &cfg.Database.Name,
+		"DB_SSLMODE":              &cfg.Database.SSLMode,
+		"DB_CONNECT_ATTEMPTS":     &cfg.Database.ConnectAttempts,
+		"DB_CONNECT_BACKOFF":      &cfg.Database.ConnectBackoff,
+	}
+}
+
+func (cfg Config) Validate() error {
+	var problems []string
+
+	if _, err := strconv.ParseUint(cfg.Port, 10, 16); err != nil {
+		problems = append(problems, fmt.Sprintf("PORT %q is not a valid port", cfg.Port))
+	}
+	if u, err := url.Parse(cfg.OriginURL); err != nil || u.Scheme == "" || u.Host == "" {
+		problems = append(problems, "ORIGIN_URL must be an absolute URL")
+	}
+	if cfg.SessionSecret == "" {
+		problems = append(problems, "SESSION_SECRET is required")
+	}
+	if cfg.SessionTTL <= 0 {
+		problems = append(problems, "SESSION_TTL must be positive")
+	}
+	switch cfg.SessionCookieSameSite {
+	case "lax", "strict":
+	case "none":
+		if !cfg.SessionCookieSecure {
+			problems = append(problems, "SESSION_COOKIE_SAMESITE none requires SESSION_COOKIE_SECURE")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("SESSION_COOKIE_SAMESITE %q is not one of lax, strict, none", cfg.SessionCookieSameSite))
+	}
+	if cfg.GitHubClientID == "" {
+		problems = append(problems, "GITHUB_CLIENT_ID is required")
+	}
+	if cfg.GitHubClientSecret == "" {
+		problems = append(problems, "GITHUB_CLIENT_SECRET is required")
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		problems = append(problems, "SHUTDOWN_TIMEOUT must be positive")
+	}
+	if cfg.ReplyMaxDepth < 0 {
+		problems = append(problems, "REPLY_MAX_DEPTH must not be negative")
+	}
+	if cfg.ReportHideThreshold < 0 {
+		problems = append(problems, "REPORT_HIDE_THRESHOLD must not be negative")
+	}
+	for _, proxy := range cfg.TrustedProxies {
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				problems = append(problems, fmt.Sprintf("TRUSTED_PROXIES %q is not an IP address or CIDR range", proxy))
+			}
+		}
+	}
+	switch cfg.RateLimitBackend {
+	case "memory":
+	case "database":
+		if cfg.Database.Driver == "memory" {
+			problems = append(problems, "RATE_LIMIT_BACKEND database requires a SQL DB_DRIVER")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("RATE_LIMIT_BACKEND %q is not one of memory, database", cfg.RateLimitBackend))
+	}
+	if _, err := compileContentFilters(cfg.ContentFilters); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	return invalidConfig(append(problems, cfg.Database.problems()...))
+}
+
+// Validate checks only the database settings, for commands such as migrate
+// that never serve HTTP.
+func (cfg DatabaseConfig) Validate() error {
+	return invalidConfig(cfg.problems())
+}
+
+func (cfg DatabaseConfig) problems() []string {
+	var problems []string
+
+	if cfg.ConnectAttempts < 1 {
+		problems = append(problems, "DB_CONNECT_ATTEMPTS must be at least 1")
+	}
+
+	switch cfg.Driver {
+	case "mysql", "postgres":
+		if cfg.Host == "" {
+			problems = append(problems, "DB_HOST is required")
+		}
+		if cfg.Name == "" {
+			problems = append(problems, "DB_DATABASE is required")
+		}
+	case "sqlite", "memory":
+	default:
+		problems = append(problems, fmt.Sprintf("DB_DRIVER %q is not one of mysql, postgres, sqlite, memory", cfg.Driver))
+	}
+
+	return problems
+}
+
+func (cfg Config) sessionCookieSameSite() http.SameSite {
+	switch cfg.SessionCookieSameSite {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
+
+func invalidConfig(problems []string) error {
+	if len(problems) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Redacted returns a copy of the configuration with secrets masked so it
+// can be printed or logged.
+func (cfg Config) Redacted() Config {
+	redact := func(value string) string {
+		if value == "" {
+			return ""
+		}
+		return "********"
+	}
+
+	cfg.SessionSecret = redact(cfg.SessionSecret)
+	cfg.GitHubClientSecret = redact(cfg.GitHubClientSecret)
+	cfg.Database.Password = redact(cfg.Database.Password)
+	return cfg
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// loginTestSession logs user in through startUserSession from a browser
+// holding previous, which may be nil, and returns the new session cookie.
+func loginTestSession(t *testing.T, user GitHubUser, previous *http.Cookie) *http.Cookie {
+	t.Helper()
+
+	login := gin.New()
+	login.Use(sessions.Sessions("session", sessionStore))
+	login.GET("/login", func(c *gin.Context) {
+		if err := startUserSession(c, user); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+		}
+	})
+	req := httptest.NewRequest("GET", "/login", nil)
+	if previous != nil {
+		req.AddCookie(previous)
+	}
+	w := httptest.NewRecorder()
+	login.ServeHTTP(w, req)
+	wantStatus(t, w, 200)
+
+	for _, c := range w.Result().Cookies() {
+		if c.Name == "session" {
+			return c
+		}
+	}
+	t.Fatal("login did not set a session cookie")
+	return nil
+}
+
+// newTestSession logs user in through startUserSession and returns the
+// session cookie together with its CSRF token, as a browser would hold them.
+func newTestSession(t *testing.T, router http.Handler, user GitHubUser) (*http.Cookie, string) {
+	t.Helper()
+
+	cookie := loginTestSession(t, user, nil)
+	w := doCookieRequest(t, router, "GET", "/api/", cookie, "", nil)
+	wantStatus(t, w, 200)
+	var main struct {
+		LoggedIn  bool   `json:"logged_in"`
+		CSRFToken string `json:"csrf_token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &main); err != nil {
+		t.Fatal(err)
+	}
+	if !main.LoggedIn || main.CSRFToken == "" {
+		t.Fatalf("/api/ with the session cookie = %s", w.Body.String())
+	}
+	return cookie, main.CSRFToken
+}
+
+func doCookieRequest(t *testing.T, router http.Handler, method, path string, cookie *http.Cookie, csrf string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var reader io.Reader = http.NoBody
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	if csrf != "" {
+		req.Header.Set(csrfHeader, csrf)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestCSRFProtect(t *testing.T) {
+	router := newTestRouter(t)
+	newTestUser(t, 1, "owner")
+	author, bearer := newTestUser(t, 2, "author")
+	cookie, csrf := newTestSession(t, router, author)
+
+	post := func(csrf string) *httptest.ResponseRecorder {
+		return doCookieRequest(t, router, "POST", "/api/user/owner/comments", cookie, csrf, gin.H{"content": "hello"})
+	}
+
+	wantStatus(t, post(""), 403)
+	wantStatus(t, post("wrong"), 403)
+	wantStatus(t, post(csrf[:len(csrf)-1]), 403)
+	if comments := listComments(t, router, "owner", ""); len(comments) != 0 {
+		t.Fatalf("a rejected request wrote %+v", comments)
+	}
+	wantStatus(t, post(csrf), 200)
+
+	for _, route := range []struct{ method, path string }{
+		{"PATCH", "/api/user/owner/comments"},
+		{"PUT", "/api/user/author/pins"},
+		{"DELETE", "/api/user/owner/comments"},
+	} {
+		w := doCookieRequest(t, router, route.method, route.path, cookie, "", gin.H{"content": "edited"})
+		wantStatus(t, w, 403)
+	}
+	wantStatus(t, doCookieRequest(t, router, "PATCH", "/api/user/owner/comments", cookie, csrf, gin.H{"content": "edited"}), 200)
+
+	// Safe methods never need the token, with or without a session.
+	wantStatus(t, doCookieRequest(t, router, "GET", "/api/user/owner/comments", cookie, "", nil), 200)
+	wantStatus(t, doCookieRequest(t, router, "GET", "/api/user/owner/comments", nil, "", nil), 200)
+
+	// Bearer tokens are exempt even when a session cookie is also sent.
+	req := httptest.NewRequest("DELETE", "/api/user/owner/comments", http.NoBody)
+	req.Header.Set("Authorization", "Bearer "+bearer)
+	req.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	wantStatus(t, w, 200)
+	wantStatus(t, doRequest(t, router, "POST", "/api/user/owner/comments", bearer, gin.H{"content": "again"}), 200)
+
+	// Without a session there is nothing to forge, so authentication decides.
+	wantStatus(t, doCookieRequest(t, router, "POST", "/api/user/owner/comments", nil, "", gin.H{"content": "anonymous"}), 401)
+}
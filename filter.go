@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	filterReject   = "reject"
+	filterMask     = "mask"
+	filterModerate = "moderate"
+)
+
+var filterActions = []string{filterReject, filterMask, filterModerate}
+
+const (
+	maxFilterRules         = 50
+	maxFilterPatternLength = 100
+)
+
+var errRejectedContent = errors.New("Comment contains blocked content")
+
+// globalContentFilters are compiled from config.ContentFilters at startup
+// and apply to every board.
+var globalContentFilters []contentFilter
+
+// ContentFilterConfig is a deny-list entry. Pattern is matched
+// case-insensitively, as a plain substring unless Regex is set.
+type ContentFilterConfig struct {
+	Pattern string `json:"pattern"`
+	Regex   bool   `json:"regex"`
+	Action  string `json:"action"`
+}
+
+// FilterRule is a deny-list entry an owner added for their own board.
+type FilterRule struct {
+	ID         uint `gorm:"primary_key"`
+	ReceiverID uint
+	Pattern    string
+	Regex      bool
+	Action     string
+	CreatedAt  time.Time
+}
+
+type FilterRuleResponse struct {
+	ID        uint      `json:"id"`
+	Pattern   string    `json:"pattern"`
+	Regex     bool      `json:"regex"`
+	Action    string    `json:"action"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type contentFilter struct {
+	re     *regexp.Regexp
+	action string
+}
+
+func compileContentFilter(pattern string, regex bool, action string) (contentFilter, error) {
+	if pattern == "" || len(pattern) > maxFilterPatternLength {
+		return contentFilter{}, fmt.Errorf("Pattern must be 1 to %d characters", maxFilterPatternLength)
+	}
+
+	switch action {
+	case filterReject, filterMask, filterModerate:
+	default:
+		return contentFilter{}, errors.New("Action must be one of " + strings.Join(filterActions, ", "))
+	}
+
+	if !regex {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	re, err := regexp.Compile("(?i)" + pattern)
+	if err != nil {
+		return contentFilter{}, fmt.Errorf("Invalid pattern: %v", err)
+	}
+	if re.MatchString("") {
+		return contentFilter{}, errors.New("Pattern must not match empty text")
+	}
+	return contentFilter{re: re, action: action}, nil
+}
+
+func compileContentFilters(entries []ContentFilterConfig) ([]contentFilter, error) {
+	filters := make([]contentFilter, 0, len(entries))
+	for i, entry := range entries {
+		filter, err := compileContentFilter(entry.Pattern, entry.Regex, entry.Action)
+		if err != nil {
+			return nil, fmt.Errorf("content_filters[%d]: %w", i, err)
+		}
+		filters = append(filters, filter)
+	}
+	return filters, nil
+}
+
+// boardContentFilters returns the global filters followed by the rules the
+// owner of receiverID added.
+func boardContentFilters(receiverID uint) ([]contentFilter, error) {
+	rules, err := store.ListFilterRules(receiverID)
+	if err != nil {
+		return nil, err
+	}
+
+	filters := append([]contentFilter(nil), globalContentFilters...)
+	for _, rule := range rules {
+		filter, err := compileContentFilter(rule.Pattern, rule.Regex, rule.Action)
+		if err != nil {
+			fmt.Println("Error compiling filter rule", rule.ID, ":", err)
+			continue
+		}
+		filters = append(filters, filter)
+	}
+	return filters, nil
+}
+
+// filterContent runs content through filters. Any reject match fails the
+// whole comment; otherwise mask matches are replaced by asterisks and
+// flagged reports whether a moderate filter matched, meaning the comment
+// should wait for approval.
+func filterContent(content string, filters []contentFilter) (filtered string, flagged bool, err error) {
+	for _, filter := range filters {
+		if filter.action == filterReject && filter.re.MatchString(content) {
+			return "", false, errRejectedContent
+		}
+	}
+
+	filtered = content
+	for _, filter := range filters {
+		switch filter.action {
+		case filterMask:
+			filtered = filter.re.ReplaceAllStringFunc(filtered, func(match string) string {
+				return strings.Repeat("*", utf8.RuneCountInString(match))
+			})
+		case filterModerate:
+			if filter.re.MatchString(content) {
+				flagged = true
+			}
+		}
+	}
+	return filtered, flagged, nil
+}
+
+func listFilterRules(c *gin.Context) {
+	receiver, ok := boardOwner(c)
+	if !ok {
+		return
+	}
+
+	rules, err := store.ListFilterRules(receiver.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to get filters"})
+		return
+	}
+
+	responses := make([]FilterRuleResponse, 0, len(rules))
+	for _, rule := range rules {
+		responses = append(responses, newFilterRuleResponse(rule))
+	}
+	c.JSON(200, responses)
+}
+
+func createFilterRule(c *gin.Context) {
+	receiver, ok := boardOwner(c)
+	if !ok {
+		return
+	}
+
+	var req ContentFilterConfig
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := compileContentFilter(req.Pattern, req.Regex, req.Action); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	rules, err := store.ListFilterRules(receiver.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to get filters"})
+		return
+	}
+	if len(rules) >= maxFilterRules {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("You can have at most %d filters", maxFilterRules)})
+		return
+	}
+
+	rule := FilterRule{
+		ReceiverID: receiver.ID,
+		Pattern:    req.Pattern,
+		Regex:      req.Regex,
+		Action:     req.Action,
+	}
+	if err := store.CreateFilterRule(&rule); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to create filter"})
+		return
+	}
+
+	c.JSON(201, newFilterRuleResponse(rule))
+}
+
+func deleteFilterRule(c *gin.Context) {
+	receiver, ok := boardOwner(c)
+	if !ok {
+		return
+	}
+
+	ruleID, err := strconv.ParseUint(c.Param("filterID"), 10, 64)
+	if err != nil || ruleID == 0 {
+		c.JSON(400, gin.H{"error": "Invalid filter ID"})
+		return
+	}
+
+	if err := store.DeleteFilterRule(receiver.ID, uint(ruleID)); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			c.JSON(404, gin.H{"error": "Filter not found"})
+		} else {
+			c.JSON(500, gin.H{"error": "Failed to delete filter"})
+		}
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Filter deleted"})
+}
+
+func newFilterRuleResponse(rule FilterRule) FilterRuleResponse {
+	return FilterRuleResponse{
+		ID:        rule.ID,
+		Pattern:   rule.Pattern,
+		Regex:     rule.Regex,
+		Action:    rule.Action,
+		CreatedAt: rule.CreatedAt,
+	}
+}
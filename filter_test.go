@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCompileContentFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		regex   bool
+		action  string
+		wantErr bool
+	}{
+		{"substring", "spam", false, filterReject, false},
+		{"regex", `b[a4]d`, true, filterMask, false},
+		{"regex metacharacters are literal without regex", "a.*", false, filterMask, false},
+		{"empty pattern", "", false, filterReject, true},
+		{"pattern too long", strings.Repeat("a", maxFilterPatternLength+1), false, filterReject, true},
+		{"unknown action", "spam", false, "delete", true},
+		{"invalid regex", "(", true, filterReject, true},
+		{"regex matching empty text", "x*", true, filterReject, true},
+		{"regex matching only empty text", "^$", true, filterMask, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := compileContentFilter(tt.pattern, tt.regex, tt.action)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("compileContentFilter(%q, %v, %q) error = %v, want error %v", tt.pattern, tt.regex, tt.action, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFilterContent(t *testing.T) {
+	type rule struct {
+		pattern string
+		regex   bool
+		action  string
+	}
+	tests := []struct {
+		name        string
+		rules       []rule
+		content     string
+		want        string
+		wantFlagged bool
+		wantErr     error
+	}{
+		{
+			name:    "no filters",
+			content: "hello",
+			want:    "hello",
+		},
+		{
+			name:    "no match",
+			rules:   []rule{{"spam", false, filterReject}},
+			content: "hello",
+			want:    "hello",
+		},
+		{
+			name:    "reject",
+			rules:   []rule{{"spam", false, filterReject}},
+			content: "buy spam now",
+			wantErr: errRejectedContent,
+		},
+		{
+			name:    "reject wins over mask",
+			rules:   []rule{{"buy", false, filterMask}, {"spam", false, filterReject}},
+			content: "buy spam now",
+			wantErr: errRejectedContent,
+		},
+		{
+			name:    "mask every occurrence",
+			rules:   []rule{{"darn", false, filterMask}},
+			content: "darn it, darn",
+			want:    "**** it, ****",
+		},
+		{
+			name:    "mask counts runes",
+			rules:   []rule{{"바보", false, filterMask}},
+			content: "너는 바보야",
+			want:    "너는 **야",
+		},
+		{
+			name:    "mask regex",
+			rules:   []rule{{`b[a4]d`, true, filterMask}},
+			content: "bad b4d bed",
+			want:    "*** *** bed",
+		},
+		{
+			name:    "substring metacharacters are literal",
+			rules:   []rule{{"a.c", false, filterMask}},
+			content: "abc a.c",
+			want:    "abc ***",
+		},
+		{
+			name:        "moderate",
+			rules:       []rule{{"link", false, filterModerate}},
+			content:     "check this link",
+			want:        "check this link",
+			wantFlagged: true,
+		},
+		{
+			name:        "moderate matches before masking",
+			rules:       []rule{{"link", false, filterMask}, {"link", false, filterModerate}},
+			content:     "check this link",
+			want:        "check this ****",
+			wantFlagged: true,
+		},
+		{
+			name:    "reject is case-insensitive",
+			rules:   []rule{{"SpAm", false, filterReject}},
+			content: "SPAM",
+			wantErr: errRejectedContent,
+		},
+		{
+			name:    "mask is case-insensitive",
+			rules:   []rule{{"darn", false, filterMask}},
+			content: "DaRn",
+			want:    "****",
+		},
+		{
+			name:        "moderate is case-insensitive",
+			rules:       []rule{{`https?://`, true, filterModerate}},
+			content:     "HTTPS://example.com",
+			want:        "HTTPS://example.com",
+			wantFlagged: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var filters []contentFilter
+			for _, r := range tt.rules {
+				filter, err := compileContentFilter(r.pattern, r.regex, r.action)
+				if err != nil {
+					t.Fatalf("compileContentFilter(%q): %v", r.pattern, err)
+				}
+				filters = append(filters, filter)
+			}
+
+			got, flagged, err := filterContent(tt.content, filters)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want || flagged != tt.wantFlagged {
+				t.Errorf("filterContent(%q) = %q, %v, want %q, %v", tt.content, got, flagged, tt.want, tt.wantFlagged)
+			}
+		})
+	}
+}
+
+func TestCompileContentFilters(t *testing.T) {
+	filters, err := compileContentFilters([]ContentFilterConfig{
+		{Pattern: "spam", Action: filterReject},
+		{Pattern: `\d{3}-\d{4}`, Regex: true, Action: filterMask},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filters) != 2 {
+		t.Fatalf("got %d filters, want 2", len(filters))
+	}
+
+	_, err = compileContentFilters([]ContentFilterConfig{
+		{Pattern: "spam", Action: filterReject},
+		{Pattern: ".*", Regex: true, Action: filterMask},
+	})
+	if err == nil {
+		t.Fatal("expected an error for a filter matching empty text")
+	}
+}
@@ -2,18 +2,21 @@ package main
 
 import (
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"html/template"
 	"net/http"
+	"net/url"
 	"os"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
-	"sync"
+	"time"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-contrib/sessions/cookie"
@@ -21,58 +24,45 @@ import (
 	_ "github.com/jinzhu/gorm/dialects/mysql"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/github"
-	"gorm.io/driver/mysql"
-	"gorm.io/gorm"
 )
 
 var (
-	db               *gorm.DB
-	store            = cookie.NewStore([]byte(os.Getenv("SESSION_SECRET")))
-	githubOauthCfg   *oauth2.Config
-	oauthStateString string
-	commentMutex     sync.Mutex
+	config         Config
+	store          Store
+	sessionStore   cookie.Store
+	boardSVGCache  *svgCache
+	githubOauthCfg *oauth2.Config
 )
 
-func init() {
-	dbHost := os.Getenv("DB_HOST")
-	dbPort := os.Getenv("DB_PORT")
-	dbUser := os.Getenv("DB_USER")
-	dbPassword := os.Getenv("DB_PASSWORD")
-	dbName := os.Getenv("DB_DATABASE")
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local", dbUser, dbPassword, dbHost, dbPort, dbName)
-	var err error
-	db, err = gorm.Open(mysql.Open(dsn), &gorm.Config{})
-	if err != nil {
-		fmt.Println("Error connecting to database:", err)
-	}
-
-	err = db.AutoMigrate(&GitHubUser{}, &Comment{}, &Liked{}, &Disliked{})
-	if err != nil {
-		fmt.Println("Error migrating database:", err)
-	}
-
-	githubOauthCfg = &oauth2.Config{
-		RedirectURL:  os.Getenv("ORIGIN_URL") + "/api/auth/callback",
-		ClientID:     os.Getenv("GITHUB_CLIENT_ID"),
-		ClientSecret: os.Getenv("GITHUB_CLIENT_SECRET"),
-		Endpoint:     github.Endpoint,
-	}
-
-	oauthStateString = generateStateString()
-}
+const oauthStateTTL = 10 * time.Minute
 
 type GitHubUser struct {
 	ID          uint    `gorm:"primary_key"`
 	GitHubID    float64 `json:"github_id"`
 	GitHubLogin string  `json:"github_login"`
+	// BoardRevision is bumped on every write to the user's comment board and
+	// identifies its current version in ETags.
+	BoardRevision int64 `gorm:"not null;default:0" json:"-"`
+	// RequireApproval holds new comments from other users on the board until
+	// the owner approves them.
+	RequireApproval bool `gorm:"not null;default:false" json:"-"`
 }
 
 type Comment struct {
-	ID           uint   `gorm:"primary_key"`
-	ReceiverID   uint   `json:"receiver_id"`
-	AuthorID     uint   `json:"author_id"`
-	Content      string `json:"content"`
-	IsOwnerLiked bool   `json:"is_owner_liked default:false"`
+	ID             uint      `gorm:"primary_key"`
+	ReceiverID     uint      `json:"receiver_id"`
+	AuthorID       uint      `json:"author_id"`
+	ParentID       uint      `gorm:"not null;default:0" json:"parent_id"`
+	Content        string    `json:"content"`
+	IsOwnerLiked   bool      `json:"is_owner_liked default:false"`
+	Hidden         bool      `gorm:"not null;default:false" json:"hidden"`
+	Pending        bool      `gorm:"not null;default:false" json:"pending"`
+	LikeCount      int       `gorm:"not null;default:0" json:"like_count"`
+	DislikeCount   int       `gorm:"not null;default:0" json:"dislike_count"`
+	PinnedPosition int       `gorm:"not null;default:0" json:"pinned_position"`
+	ReportCount    int       `gorm:"not null;default:0" json:"-"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
 }
 
 type Liked struct {
@@ -88,41 +78,176 @@ type Disliked struct {
 }
 
 type CommentResponse struct {
-	ID           uint   `json:"id"`
-	Author       string `json:"author"`
-	Content      string `json:"content"`
-	IsOwnerLiked bool   `json:"is_owner_liked"`
-	IsLiked      bool   `json:"is_liked"`
-	IsDisliked   bool   `json:"is_disliked"`
-	Likes        int    `json:"likes"`
-	Dislikes     int    `json:"dislikes"`
+	ID             uint              `json:"id"`
+	ParentID       uint              `json:"parent_id"`
+	Author         string            `json:"author"`
+	Content        string            `json:"content"`
+	IsOwnerLiked   bool              `json:"is_owner_liked"`
+	Hidden         bool              `json:"hidden"`
+	Pending        bool              `json:"pending"`
+	IsLiked        bool              `json:"is_liked"`
+	IsDisliked     bool              `json:"is_disliked"`
+	Likes          int               `json:"likes"`
+	Dislikes       int               `json:"dislikes"`
+	PinnedPosition int               `json:"pinned_position"`
+	CreatedAt      time.Time         `json:"created_at"`
+	UpdatedAt      time.Time         `json:"updated_at"`
+	Edited         bool              `json:"edited"`
+	Replies        []CommentResponse `json:"replies"`
 }
 
 type SvgCommentModel struct {
-	ID           uint
-	Author       string
-	Content      string
-	Likes        int
-	Dislikes     int
-	IsOwnerLiked bool
+	ID             uint
+	ParentID       uint
+	Depth          int
+	Author         string
+	Content        string
+	Likes          int
+	Dislikes       int
+	IsOwnerLiked   bool
+	Edited         bool
+	PinnedPosition int
 }
 
 func main() {
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a JSON config file")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration with secrets redacted and exit")
+	flag.Parse()
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if *printConfig {
+		out, _ := json.MarshalIndent(cfg.Redacted(), "", "  ")
+		fmt.Println(string(out))
+		if err := cfg.Validate(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if flag.NArg() > 0 {
+		if err := runCommand(cfg.Database, flag.Args()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	config = cfg
+
+	store, err = connectStore(config.Database)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer store.Close()
+
+	rateLimiter, err = newRateLimiter(config.RateLimitBackend, store)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		store.Close()
+		os.Exit(1)
+	}
+
+	if err := store.Migrate(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error migrating database:", err)
+		store.Close()
+		os.Exit(1)
+	}
+
+	sessionStore = cookie.NewStore([]byte(config.SessionSecret))
+	sessionStore.Options(sessions.Options{
+		Path:     "/",
+		MaxAge:   int(time.Duration(config.SessionTTL).Seconds()),
+		HttpOnly: true,
+		Secure:   config.SessionCookieSecure,
+		SameSite: config.sessionCookieSameSite(),
+	})
+	boardSVGCache = newSVGCache(time.Duration(config.SVGCacheTTL))
+	globalContentFilters, _ = compileContentFilters(config.ContentFilters)
+
+	githubOauthCfg = &oauth2.Config{
+		RedirectURL:  config.OriginURL + "/api/auth/callback",
+		ClientID:     config.GitHubClientID,
+		ClientSecret: config.GitHubClientSecret,
+		Endpoint:     github.Endpoint,
+	}
+
+	router := newRouter()
+
+	if err := serve(router); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		store.Close()
+		os.Exit(1)
+	}
+}
+
+func newRouter() *gin.Engine {
 	router := gin.Default()
 
-	router.Use(sessions.Sessions("session", store))
+	// Only trust X-Forwarded-For from the configured proxies, so that
+	// clients can't pick their own address for per-IP rate limits.
+	if err := router.SetTrustedProxies(config.TrustedProxies); err != nil {
+		fmt.Println("Error setting trusted proxies:", err)
+		router.SetTrustedProxies(nil)
+	}
+
+	router.Use(sessions.Sessions("session", sessionStore))
+
+	commentLimit := Throttle("comments", &config.CommentRateLimit, &config.CommentIPRateLimit)
+	reactionLimit := Throttle("reactions", &config.ReactionRateLimit, &config.ReactionIPRateLimit)
 
-	api := router.Group("api")
+	api := router.Group("api", CSRFProtect())
 	{
-		api.GET("/", handleMain)
+		api.GET("/", OptionalAuth(scopeRead), handleMain)
 		api.GET("/users", getUsers)
 
 		user := api.Group("/user")
 		{
-			user.POST("/:username/comments", createComment)
-			user.GET("/:username/comments", getComments)
-			user.DELETE("/:username/comments", deleteComment)
+			user.POST("/:username/comments", RequireAuth(scopeComment), commentLimit, createComment)
+			user.GET("/:username/comments", OptionalAuth(scopeRead), getComments)
+			user.PATCH("/:username/comments", RequireAuth(scopeComment), commentLimit, editComment)
+			user.DELETE("/:username/comments", RequireAuth(scopeComment), commentLimit, deleteComment)
 			user.GET("/:username/svg", getUserCommentSVG)
+			user.POST("/:username/pins/:commentID", RequireAuth(scopeModerate), pinComment)
+			user.DELETE("/:username/pins/:commentID", RequireAuth(scopeModerate), unpinComment)
+			user.PUT("/:username/pins", RequireAuth(scopeModerate), reorderPins)
+			user.POST("/:username/hidden/:commentID", RequireAuth(scopeModerate), hideComment)
+			user.DELETE("/:username/hidden/:commentID", RequireAuth(scopeModerate), unhideComment)
+			user.DELETE("/:username/comments/:commentID", RequireAuth(scopeModerate), moderateDeleteComment)
+			user.GET("/:username/moderation", RequireAuth(scopeModerate), listModerationActions)
+			user.GET("/:username/settings", RequireAuth(scopeModerate), getBoardSettings)
+			user.PUT("/:username/settings", RequireAuth(scopeModerate), updateBoardSettings)
+			user.GET("/:username/pending", RequireAuth(scopeModerate), listPendingComments)
+			user.POST("/:username/pending/:commentID", RequireAuth(scopeModerate), approveComment)
+			user.DELETE("/:username/pending/:commentID", RequireAuth(scopeModerate), rejectComment)
+			user.GET("/:username/filters", RequireAuth(scopeModerate), listFilterRules)
+			user.POST("/:username/filters", RequireAuth(scopeModerate), createFilterRule)
+			user.DELETE("/:username/filters/:filterID", RequireAuth(scopeModerate), deleteFilterRule)
+			user.GET("/:username/blocks", RequireAuth(scopeModerate), listBlocks)
+			user.POST("/:username/blocks", RequireAuth(scopeModerate), blockUser)
+			user.DELETE("/:username/blocks/:login", RequireAuth(scopeModerate), unblockUser)
+		}
+
+		comments := api.Group("/comments")
+		{
+			comments.POST("/:commentID/report", RequireAuth(scopeComment), commentLimit, reportComment)
+		}
+
+		reports := api.Group("/reports", RequireAuth(scopeModerate), RequireAdmin())
+		{
+			reports.GET("", listReports)
+			reports.POST("/:reportID/resolve", resolveReport)
+			reports.POST("/:reportID/dismiss", dismissReport)
 		}
 
 		auth := api.Group("/auth")
@@ -132,14 +257,28 @@ func main() {
 			auth.GET("/logout", handleLogout)
 		}
 
+		tokens := api.Group("/tokens", RequireSession())
+		{
+			tokens.GET("", listAPITokens)
+			tokens.POST("", createAPIToken)
+			tokens.DELETE("/:tokenID", revokeAPIToken)
+		}
+
+		userSessions := api.Group("/sessions", RequireSession())
+		{
+			userSessions.GET("", listUserSessions)
+			userSessions.DELETE("", logoutEverywhere)
+			userSessions.DELETE("/:sessionID", revokeUserSession)
+		}
+
 		like := api.Group("/like")
 		{
-			like.POST("/like/:commentID", likeComment)
-			like.POST("/remove-like/:commentID", removeLike)
-			like.POST("/dislike/:commentID", dislikeComment)
-			like.POST("/remove-dislike/:commentID", removeDislike)
-			like.POST("/owner-like/:commentID", ownerLikeComment)
-			like.POST("/owner-remove-like/:commentID", ownerRemoveLike)
+			like.POST("/like/:commentID", RequireAuth(scopeReact), reactionLimit, likeComment)
+			like.POST("/remove-like/:commentID", RequireAuth(scopeReact), reactionLimit, removeLike)
+			like.POST("/dislike/:commentID", RequireAuth(scopeReact), reactionLimit, dislikeComment)
+			like.POST("/remove-dislike/:commentID", RequireAuth(scopeReact), reactionLimit, removeDislike)
+			like.POST("/owner-like/:commentID", RequireAuth(scopeModerate), reactionLimit, ownerLikeComment)
+			like.POST("/owner-remove-like/:commentID", RequireAuth(scopeModerate), reactionLimit, ownerRemoveLike)
 		}
 	}
 	router.StaticFile("/favicon.ico", "./favicon.ico")
@@ -147,34 +286,31 @@ func main() {
 		c.File("index.html")
 	})
 
-	router.Run(":" + os.Getenv("PORT"))
+	return router
 }
 
 func handleMain(c *gin.Context) {
-	session := sessions.Default(c)
-	githubID := session.Get("github_id")
-
-	if githubID != nil {
-		var gitHubUser GitHubUser
-		if err := db.Where(&GitHubUser{GitHubID: githubID.(float64)}).First(&gitHubUser).Error; err != nil {
-			c.JSON(500, gin.H{"error": "Failed to get GitHub login"})
-		}
-
+	if principal := currentPrincipal(c); principal != nil {
 		c.JSON(http.StatusOK, gin.H{
-			"user_id":   gitHubUser.GitHubLogin,
-			"logged_in": true,
+			"user_id":    principal.User.GitHubLogin,
+			"logged_in":  true,
+			"csrf_token": csrfToken(c),
 		})
 	} else {
 		c.JSON(http.StatusOK, gin.H{
-			"user_id":   "Not logged in",
-			"logged_in": false,
+			"user_id":    "Not logged in",
+			"logged_in":  false,
+			"csrf_token": csrfToken(c),
 		})
 	}
 }
 
 func getUsers(c *gin.Context) {
-	var users []GitHubUser
-	db.Find(&users)
+	users, err := store.ListUsers()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to get users"})
+		return
+	}
 	c.JSON(200, users)
 }
 
@@ -185,82 +321,138 @@ func createComment(c *gin.Context) {
 		return
 	}
 
-	var receiver GitHubUser
-	if err := db.Where(&GitHubUser{GitHubLogin: username}).First(&receiver).Error; err != nil {
+	receiver, err := store.FindUserByLogin(username)
+	if err != nil {
 		c.JSON(404, gin.H{"error": "GitHub user not found"})
 		return
 	}
 
-	session := sessions.Default(c)
-	authorID := session.Get("github_id")
-	if authorID == nil {
-		c.JSON(401, gin.H{"error": "Unauthorized"})
-		return
-	}
+	author := currentUser(c)
 
-	var author GitHubUser
-	if err := db.Where(&GitHubUser{GitHubID: authorID.(float64)}).First(&author).Error; err != nil {
-		c.JSON(401, gin.H{"error": "Unauthorized"})
+	if !checkNotBlocked(c, receiver.ID) {
 		return
 	}
 
 	var req struct {
-		Content string `json:"content"`
+		Content  string `json:"content"`
+		ParentID uint   `json:"parent_id"`
 	}
 	if err := c.BindJSON(&req); err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
 
-	if req.Content == "" {
-		c.JSON(400, gin.H{"error": "Content not provided"})
+	filters, err := boardContentFilters(receiver.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to get content filters"})
 		return
 	}
 
-	if len(req.Content) > 35 {
-		runes := []rune(req.Content)
-		if len(runes) > 35 {
-			req.Content = string(runes[:35])
+	content, flagged, err := sanitizeCommentContent(req.Content, filters)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.ParentID != 0 {
+		if err := checkReply(receiver, author, req.ParentID); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
 		}
 	}
 
-	if hasZalgo(req.Content) {
-		c.JSON(400, gin.H{"error": "Invalid content"})
+	comment := Comment{
+		AuthorID:   author.ID,
+		ReceiverID: receiver.ID,
+		ParentID:   req.ParentID,
+		Content:    content,
+		Pending:    needsApproval(receiver, author, flagged),
+	}
+
+	if err := store.CreateComment(&comment); err != nil {
+		if errors.Is(err, ErrCommentExists) {
+			c.JSON(400, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(500, gin.H{"error": "Failed to create comment"})
+		}
 		return
 	}
 
-	commentMutex.Lock()
-	defer commentMutex.Unlock()
+	touchBoard(receiver.ID)
 
-	err := db.Transaction(func(tx *gorm.DB) error {
-		var existing Comment
-		if err := tx.Where(&Comment{ReceiverID: receiver.ID, AuthorID: author.ID}).First(&existing).Error; err == nil {
-			return errors.New("user already has a comment")
-		}
+	if comment.Pending {
+		c.JSON(200, gin.H{"message": "Comment submitted for approval", "pending": true})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Comment created"})
+}
 
-		comment := Comment{
-			AuthorID:   author.ID,
-			ReceiverID: receiver.ID,
-			Content:    escapeHTML(req.Content),
-		}
+func editComment(c *gin.Context) {
+	username := c.Param("username")
+	if username == "" {
+		c.JSON(400, gin.H{"error": "Username not provided"})
+		return
+	}
 
-		if err := tx.Create(&comment).Error; err != nil {
-			return err
-		}
+	receiver, err := store.FindUserByLogin(username)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "GitHub user not found"})
+		return
+	}
 
-		return nil
-	})
+	author := currentUser(c)
+
+	if !checkNotBlocked(c, receiver.ID) {
+		return
+	}
+
+	parentID, ok := parentIDQuery(c)
+	if !ok {
+		return
+	}
 
+	var req struct {
+		Content string `json:"content"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	filters, err := boardContentFilters(receiver.ID)
 	if err != nil {
-		if err.Error() == "user already has a comment" {
-			c.JSON(400, gin.H{"error": err.Error()})
-		} else {
-			c.JSON(500, gin.H{"error": "Failed to create comment"})
-		}
+		c.JSON(500, gin.H{"error": "Failed to get content filters"})
 		return
 	}
 
-	c.JSON(200, gin.H{"message": "Comment created"})
+	content, flagged, err := sanitizeCommentContent(req.Content, filters)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	existing, err := store.FindCommentByAuthor(receiver.ID, author.ID, parentID)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Comment not found"})
+		return
+	}
+
+	if existing.Content == content {
+		c.JSON(200, gin.H{"message": "Comment unchanged"})
+		return
+	}
+
+	// An approved comment goes back into the queue when it is edited, so
+	// that approval can't be used to slip in different text.
+	pending := !existing.Pending && needsApproval(receiver, author, flagged)
+	if err := store.UpdateCommentContent(existing.ID, content, pending); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to edit comment"})
+		return
+	}
+
+	touchBoard(receiver.ID)
+
+	c.JSON(200, gin.H{"message": "Comment edited"})
 }
 
 func getComments(c *gin.Context) {
@@ -270,55 +462,67 @@ func getComments(c *gin.Context) {
 		return
 	}
 
-	var gitHubUser GitHubUser
-	if err := db.Where(&GitHubUser{GitHubLogin: username}).First(&gitHubUser).Error; err != nil {
+	gitHubUser, err := store.FindUserByLogin(username)
+	if err != nil {
 		c.JSON(404, gin.H{"error": "GitHub user not found"})
 		return
 	}
 
-	session := sessions.Default(c)
-	userID := session.Get("github_id")
-
 	var user GitHubUser
-	isLoggedIn := userID != nil && db.Where(&GitHubUser{GitHubID: userID.(float64)}).First(&user).Error == nil
+	principal := currentPrincipal(c)
+	isLoggedIn := principal != nil
+	if isLoggedIn {
+		user = principal.User
+	}
 
-	var comments []Comment
-	db.Where(&Comment{ReceiverID: gitHubUser.ID}).Find(&comments)
+	c.Header("Cache-Control", cacheControl("private", config.CommentsMaxAge))
+	c.Header("Vary", "Cookie, Authorization")
+	if notModified(c, fmt.Sprintf(`"comments-%d-%d-%d"`, gitHubUser.ID, gitHubUser.BoardRevision, user.ID)) {
+		return
+	}
+
+	comments, err := store.ListCommentViews(gitHubUser.ID, user.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to get comments"})
+		return
+	}
 
 	commentResponses := make([]CommentResponse, 0, len(comments))
 	for _, comment := range comments {
-		var author GitHubUser
-		if err := db.First(&author, comment.AuthorID).Error; err != nil {
-			fmt.Println("Error getting GitHub login:", err)
+		// Hidden comments, and with them their replies, are only shown to
+		// the owner of the board. Comments awaiting approval are also shown
+		// to their author.
+		if comment.Hidden && user.ID != gitHubUser.ID {
 			continue
 		}
-
-		var likes []Liked
-		db.Where(&Liked{CommentID: comment.ID}).Find(&likes)
-		var dislikes []Disliked
-		db.Where(&Disliked{CommentID: comment.ID}).Find(&dislikes)
-
-		isLiked := false
-		isDisliked := false
-		if isLoggedIn {
-			db.Where(&Liked{CommentID: comment.ID, UserID: user.ID}).First(&Liked{})
-			isLiked = db.Where(&Liked{CommentID: comment.ID, UserID: user.ID}).First(&Liked{}).Error == nil
-			isDisliked = db.Where(&Disliked{CommentID: comment.ID, UserID: user.ID}).First(&Disliked{}).Error == nil
+		if comment.Pending && user.ID != gitHubUser.ID && comment.AuthorID != user.ID {
+			continue
 		}
-
 		commentResponses = append(commentResponses, CommentResponse{
-			ID:           comment.ID,
-			Author:       author.GitHubLogin,
-			Content:      comment.Content,
-			IsOwnerLiked: comment.IsOwnerLiked,
-			IsLiked:      isLiked,
-			IsDisliked:   isDisliked,
-			Likes:        len(likes),
-			Dislikes:     len(dislikes),
+			ID:             comment.ID,
+			ParentID:       comment.ParentID,
+			Author:         comment.Author,
+			Content:        comment.Content,
+			IsOwnerLiked:   comment.IsOwnerLiked,
+			Hidden:         comment.Hidden,
+			Pending:        comment.Pending,
+			IsLiked:        comment.IsLiked,
+			IsDisliked:     comment.IsDisliked,
+			Likes:          comment.Likes,
+			Dislikes:       comment.Dislikes,
+			PinnedPosition: comment.PinnedPosition,
+			CreatedAt:      comment.CreatedAt,
+			UpdatedAt:      comment.UpdatedAt,
+			Edited:         comment.Edited(),
 		})
 	}
 
+	commentResponses = nestCommentResponses(commentResponses)
+
 	sort.Slice(commentResponses, func(i, j int) bool {
+		if before, ok := pinnedFirst(commentResponses[i].PinnedPosition, commentResponses[j].PinnedPosition); ok {
+			return before
+		}
 		if isLoggedIn {
 			if commentResponses[i].Author == user.GitHubLogin {
 				return true
@@ -343,36 +547,32 @@ func deleteComment(c *gin.Context) {
 		return
 	}
 
-	var receiver GitHubUser
-	if err := db.Where(&GitHubUser{GitHubLogin: username}).First(&receiver).Error; err != nil {
+	receiver, err := store.FindUserByLogin(username)
+	if err != nil {
 		c.JSON(404, gin.H{"error": "GitHub user not found"})
 		return
 	}
 
-	session := sessions.Default(c)
-	authorID := session.Get("github_id")
-	if authorID == nil {
-		c.JSON(401, gin.H{"error": "Unauthorized"})
-		return
-	}
+	author := currentUser(c)
 
-	var author GitHubUser
-	if err := db.Where(&GitHubUser{GitHubID: authorID.(float64)}).First(&author).Error; err != nil {
-		c.JSON(401, gin.H{"error": "Unauthorized"})
+	parentID, ok := parentIDQuery(c)
+	if !ok {
 		return
 	}
 
-	var existing Comment
-	if err := db.Where(&Comment{ReceiverID: receiver.ID}).Where(&Comment{AuthorID: author.ID}).First(&existing).Error; err != nil {
+	existing, err := store.FindCommentByAuthor(receiver.ID, author.ID, parentID)
+	if err != nil {
 		c.JSON(404, gin.H{"error": "Comment not found"})
 		return
 	}
 
-	if err := db.Delete(&existing).Error; err != nil {
+	if err := store.DeleteComment(existing.ID); err != nil {
 		c.JSON(500, gin.H{"error": "Failed to delete comment"})
 		return
 	}
 
+	touchBoard(receiver.ID)
+
 	c.JSON(200, gin.H{"message": "Comment deleted"})
 }
 
@@ -383,48 +583,71 @@ func getUserCommentSVG(c *gin.Context) {
 		return
 	}
 
-	var gitHubUser GitHubUser
-	if err := db.Where(&GitHubUser{GitHubLogin: username}).First(&gitHubUser).Error; err != nil {
-		c.JSON(404, gin.H{"error": "GitHub user not found"})
+	theme := c.Query("theme")
+	if theme != "black" && theme != "transparent" {
+		theme = "white"
+	}
+
+	svg, err := boardSVGCache.get(username, theme, func() (renderedSVG, error) {
+		return renderCommentSVG(username, theme)
+	})
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			c.JSON(404, gin.H{"error": "GitHub user not found"})
+		} else {
+			c.JSON(500, gin.H{"error": "Failed to get comments"})
+		}
+		return
+	}
+
+	c.Writer.Header().Set("Cache-Control", cacheControl("public", config.SVGMaxAge))
+	if notModified(c, svg.ETag) {
 		return
 	}
 
-	var comments []Comment
-	db.Where(&Comment{ReceiverID: gitHubUser.ID}).Find(&comments)
+	c.Writer.Header().Set("Content-Type", "image/svg+xml")
+	c.String(http.StatusOK, svg.Content)
+}
+
+func renderCommentSVG(username, theme string) (renderedSVG, error) {
+	gitHubUser, err := store.FindUserByLogin(username)
+	if err != nil {
+		return renderedSVG{}, err
+	}
+
+	comments, err := store.ListCommentViews(gitHubUser.ID, 0)
+	if err != nil {
+		return renderedSVG{}, err
+	}
 
 	commentResponses := make([]SvgCommentModel, 0, len(comments))
 	for _, comment := range comments {
-		var author GitHubUser
-		if err := db.First(&author, comment.AuthorID).Error; err != nil {
-			fmt.Println("Error getting GitHub login:", err)
+		if comment.Hidden || comment.Pending || reportHidden(comment.ReportCount) {
 			continue
 		}
-
-		var likes []Liked
-		db.Where(&Liked{CommentID: comment.ID}).Find(&likes)
-
-		var dislikes []Disliked
-		db.Where(&Disliked{CommentID: comment.ID}).Find(&dislikes)
-
 		commentResponses = append(commentResponses, SvgCommentModel{
-			ID:           comment.ID,
-			Author:       author.GitHubLogin,
-			Content:      comment.Content,
-			Likes:        len(likes),
-			Dislikes:     len(dislikes),
-			IsOwnerLiked: comment.IsOwnerLiked,
+			ID:             comment.ID,
+			ParentID:       comment.ParentID,
+			Author:         comment.Author,
+			Content:        comment.Content,
+			Likes:          comment.Likes,
+			Dislikes:       comment.Dislikes,
+			IsOwnerLiked:   comment.IsOwnerLiked,
+			Edited:         comment.Edited(),
+			PinnedPosition: comment.PinnedPosition,
 		})
 	}
 
-	sort.Slice(commentResponses, func(i, j int) bool {
-		if commentResponses[i].IsOwnerLiked != commentResponses[j].IsOwnerLiked {
-			return commentResponses[i].IsOwnerLiked
+	commentResponses = flattenSVGComments(commentResponses, func(a, b SvgCommentModel) bool {
+		if before, ok := pinnedFirst(a.PinnedPosition, b.PinnedPosition); ok {
+			return before
 		}
-		return (commentResponses[i].Likes - commentResponses[i].Dislikes) > (commentResponses[j].Likes - commentResponses[j].Dislikes)
+		if a.IsOwnerLiked != b.IsOwnerLiked {
+			return a.IsOwnerLiked
+		}
+		return (a.Likes - a.Dislikes) > (b.Likes - b.Dislikes)
 	})
 
-	theme := c.Query("theme")
-
 	var bgColor, textColor string
 	switch theme {
 	case "black":
@@ -441,35 +664,56 @@ func getUserCommentSVG(c *gin.Context) {
 		textColor = "black"
 	}
 
-	svgContent := generateCommentBox(gitHubUser.GitHubLogin, commentResponses, textColor, bgColor)
-
-	c.Writer.Header().Set("Content-Type", "image/svg+xml")
-	c.Writer.Header().Set("Cache-Control", "no-cache")
-	c.String(http.StatusOK, svgContent)
+	return renderedSVG{
+		Content: generateCommentBox(gitHubUser.GitHubLogin, commentResponses, textColor, bgColor),
+		ETag:    fmt.Sprintf(`"svg-%d-%d-%s"`, gitHubUser.ID, gitHubUser.BoardRevision, theme),
+	}, nil
 }
 
 func handleLogin(c *gin.Context) {
-	redirectPath := c.Query("current")
+	state := generateStateString()
+	verifier := oauth2.GenerateVerifier()
 
-	githubOauthConfig := *githubOauthCfg
-
-	if redirectPath != "" {
-		githubOauthConfig.RedirectURL += "?current=" + redirectPath
+	session := sessions.Default(c)
+	session.Set("oauth_state", state)
+	session.Set("oauth_verifier", verifier)
+	session.Set("oauth_expires", time.Now().Add(oauthStateTTL).Unix())
+	if returnPath, ok := safeReturnPath(c.Query("current")); ok {
+		session.Set("oauth_return", returnPath)
+	} else {
+		session.Delete("oauth_return")
+	}
+	if err := session.Save(); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
 	}
 
-	loginURL := githubOauthConfig.AuthCodeURL(oauthStateString)
+	loginURL := githubOauthCfg.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier))
 	c.Redirect(http.StatusTemporaryRedirect, loginURL)
 }
 
 func handleCallback(c *gin.Context) {
+	session := sessions.Default(c)
+	expectedState, _ := session.Get("oauth_state").(string)
+	verifier, _ := session.Get("oauth_verifier").(string)
+	expires, _ := session.Get("oauth_expires").(int64)
+	returnPath, _ := session.Get("oauth_return").(string)
+
+	// The state is single use: drop it before anything else can fail.
+	session.Delete("oauth_state")
+	session.Delete("oauth_verifier")
+	session.Delete("oauth_expires")
+	session.Delete("oauth_return")
+	session.Save()
+
 	state := c.Query("state")
-	if state != oauthStateString {
+	if expectedState == "" || subtle.ConstantTimeCompare([]byte(state), []byte(expectedState)) != 1 || time.Now().Unix() > expires {
 		c.AbortWithError(http.StatusUnauthorized, fmt.Errorf("invalid oauth state"))
 		return
 	}
 
 	code := c.Query("code")
-	token, err := githubOauthCfg.Exchange(c, code)
+	token, err := githubOauthCfg.Exchange(c, code, oauth2.VerifierOption(verifier))
 	if err != nil {
 		c.AbortWithError(http.StatusBadRequest, err)
 		return
@@ -497,42 +741,55 @@ func handleCallback(c *gin.Context) {
 		return
 	}
 
-	session := sessions.Default(c)
-	session.Set("github_id", githubID)
-	session.Save()
-
-	var gitHubUser GitHubUser
-	if err := db.Where(&GitHubUser{GitHubID: githubID}).First(&gitHubUser).Error; err != nil {
-		gitHubUser = GitHubUser{
-			GitHubID:    githubID,
-			GitHubLogin: githubLogin,
-		}
-		if err := db.Create(&gitHubUser).Error; err != nil {
-			c.AbortWithError(http.StatusInternalServerError, err)
-			return
-		}
-	} else {
-		if gitHubUser.GitHubLogin != githubLogin {
-			if err := db.Model(&gitHubUser).Update("git_hub_login", githubLogin).Error; err != nil {
-				c.AbortWithError(http.StatusInternalServerError, err)
-				return
-			}
-		}
+	gitHubUser, err := store.SaveUser(githubID, githubLogin)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
 	}
 
-	redirectPath := c.Query("current")
-	if redirectPath != "" {
-		c.Redirect(http.StatusFound, os.Getenv("ORIGIN_URL")+redirectPath)
+	if err := startUserSession(c, gitHubUser); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message":   "Logged in successfully",
-		"github_id": githubID,
-	})
+	if returnPath == "" {
+		returnPath = "/" + githubLogin
+	}
+	c.Redirect(http.StatusFound, strings.TrimSuffix(config.OriginURL, "/")+returnPath)
+}
+
+var allowedReturnPaths = []*regexp.Regexp{
+	regexp.MustCompile(`^/$`),
+	regexp.MustCompile(`^/[A-Za-z0-9](?:[A-Za-z0-9-]{0,38})$`),
+}
+
+// safeReturnPath reports whether raw is a same-origin path that the login
+// flow may send the user back to, returning it in canonical form.
+func safeReturnPath(raw string) (string, bool) {
+	if raw == "" || !strings.HasPrefix(raw, "/") || strings.HasPrefix(raw, "//") || strings.Contains(raw, "\\") {
+		return "", false
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil {
+		return "", false
+	}
+
+	for _, allowed := range allowedReturnPaths {
+		if allowed.MatchString(u.Path) {
+			return u.Path, true
+		}
+	}
+	return "", false
 }
 
 func handleLogout(c *gin.Context) {
+	if principal, _ := authenticate(c); principal != nil && principal.Session != nil {
+		if err := store.DeleteUserSession(principal.User.ID, principal.Session.ID); err != nil {
+			fmt.Println("Error deleting session:", err)
+		}
+	}
+
 	session := sessions.Default(c)
 	session.Clear()
 	session.Save()
@@ -555,22 +812,15 @@ func likeComment(c *gin.Context) {
 		return
 	}
 
-	var comment Comment
-	if err := db.Where(&Comment{ID: uint(commentIDUint)}).First(&comment).Error; err != nil {
+	comment, err := store.FindComment(uint(commentIDUint))
+	if err != nil {
 		c.JSON(404, gin.H{"error": "Comment not found"})
 		return
 	}
 
-	session := sessions.Default(c)
-	userID := session.Get("github_id")
-	if userID == nil {
-		c.JSON(401, gin.H{"error": "Unauthorized"})
-		return
-	}
+	gitHubUser := currentUser(c)
 
-	var gitHubUser GitHubUser
-	if err := db.Where(&GitHubUser{GitHubID: userID.(float64)}).First(&gitHubUser).Error; err != nil {
-		c.JSON(401, gin.H{"error": "Unauthorized"})
+	if !checkNotBlocked(c, comment.ReceiverID) {
 		return
 	}
 
@@ -579,20 +829,20 @@ func likeComment(c *gin.Context) {
 		return
 	}
 
-	if err := db.Where(&Liked{CommentID: uint(commentIDUint), UserID: gitHubUser.ID}).First(&Liked{}).Error; err == nil {
-		c.JSON(400, gin.H{"error": "You have already liked this comment"})
-		return
-	}
-
-	if err := db.Where(&Disliked{CommentID: uint(commentIDUint), UserID: gitHubUser.ID}).First(&Disliked{}).Error; err == nil {
-		c.JSON(400, gin.H{"error": "You have already disliked this comment"})
+	if err := store.AddLike(comment.ID, gitHubUser.ID); err != nil {
+		if errors.Is(err, ErrReactionExists) {
+			c.JSON(400, gin.H{"error": "You have already liked this comment"})
+		} else if errors.Is(err, ErrReactionConflict) {
+			c.JSON(400, gin.H{"error": "You have already disliked this comment"})
+		} else if errors.Is(err, ErrNotFound) {
+			c.JSON(404, gin.H{"error": "Comment not found"})
+		} else {
+			c.JSON(500, gin.H{"error": "Failed to like comment"})
+		}
 		return
 	}
 
-	if err := db.Create(&Liked{CommentID: uint(commentIDUint), UserID: gitHubUser.ID}).Error; err != nil {
-		c.JSON(500, gin.H{"error": "Failed to like comment"})
-		return
-	}
+	touchBoard(comment.ReceiverID)
 
 	c.JSON(200, gin.H{"message": "Comment liked"})
 }
@@ -604,41 +854,36 @@ func removeLike(c *gin.Context) {
 		return
 	}
 
-	var comment Comment
 	commentIDUint, err := strconv.ParseUint(commentID, 10, 64)
 	if err != nil {
 		c.JSON(400, gin.H{"error": "Invalid Comment ID"})
 		return
 	}
 
-	if err := db.Where(&Comment{ID: uint(commentIDUint)}).First(&comment).Error; err != nil {
+	comment, err := store.FindComment(uint(commentIDUint))
+	if err != nil {
 		c.JSON(404, gin.H{"error": "Comment not found"})
 		return
 	}
 
-	session := sessions.Default(c)
-	userID := session.Get("github_id")
-	if userID == nil {
-		c.JSON(401, gin.H{"error": "Unauthorized"})
-		return
-	}
+	gitHubUser := currentUser(c)
 
-	var gitHubUser GitHubUser
-	if err := db.Where(&GitHubUser{GitHubID: userID.(float64)}).First(&gitHubUser).Error; err != nil {
-		c.JSON(401, gin.H{"error": "Unauthorized"})
+	if !checkNotBlocked(c, comment.ReceiverID) {
 		return
 	}
 
-	if err := db.Where(&Liked{CommentID: uint(commentIDUint), UserID: gitHubUser.ID}).First(&Liked{}).Error; err != nil {
+	if liked, _ := store.HasLiked(comment.ID, gitHubUser.ID); !liked {
 		c.JSON(400, gin.H{"error": "Comment not liked"})
 		return
 	}
 
-	if err := db.Where(&Liked{CommentID: uint(commentIDUint), UserID: gitHubUser.ID}).Delete(&Liked{}).Error; err != nil {
+	if err := store.RemoveLike(comment.ID, gitHubUser.ID); err != nil {
 		c.JSON(500, gin.H{"error": "Failed to remove like"})
 		return
 	}
 
+	touchBoard(comment.ReceiverID)
+
 	c.JSON(200, gin.H{"message": "Like removed"})
 }
 
@@ -655,22 +900,15 @@ func dislikeComment(c *gin.Context) {
 		return
 	}
 
-	var comment Comment
-	if err := db.Where(&Comment{ID: uint(commentIDUint)}).First(&comment).Error; err != nil {
+	comment, err := store.FindComment(uint(commentIDUint))
+	if err != nil {
 		c.JSON(404, gin.H{"error": "Comment not found"})
 		return
 	}
 
-	session := sessions.Default(c)
-	userID := session.Get("github_id")
-	if userID == nil {
-		c.JSON(401, gin.H{"error": "Unauthorized"})
-		return
-	}
+	gitHubUser := currentUser(c)
 
-	var gitHubUser GitHubUser
-	if err := db.Where(&GitHubUser{GitHubID: userID.(float64)}).First(&gitHubUser).Error; err != nil {
-		c.JSON(401, gin.H{"error": "Unauthorized"})
+	if !checkNotBlocked(c, comment.ReceiverID) {
 		return
 	}
 
@@ -679,20 +917,20 @@ func dislikeComment(c *gin.Context) {
 		return
 	}
 
-	if err := db.Where(&Disliked{CommentID: uint(commentIDUint), UserID: gitHubUser.ID}).First(&Disliked{}).Error; err == nil {
-		c.JSON(400, gin.H{"error": "You have already disliked this comment"})
-		return
-	}
-
-	if err := db.Where(&Liked{CommentID: uint(commentIDUint), UserID: gitHubUser.ID}).First(&Liked{}).Error; err == nil {
-		c.JSON(400, gin.H{"error": "You have already liked this comment"})
+	if err := store.AddDislike(comment.ID, gitHubUser.ID); err != nil {
+		if errors.Is(err, ErrReactionExists) {
+			c.JSON(400, gin.H{"error": "You have already disliked this comment"})
+		} else if errors.Is(err, ErrReactionConflict) {
+			c.JSON(400, gin.H{"error": "You have already liked this comment"})
+		} else if errors.Is(err, ErrNotFound) {
+			c.JSON(404, gin.H{"error": "Comment not found"})
+		} else {
+			c.JSON(500, gin.H{"error": "Failed to dislike comment"})
+		}
 		return
 	}
 
-	if err := db.Create(&Disliked{CommentID: uint(commentIDUint), UserID: gitHubUser.ID}).Error; err != nil {
-		c.JSON(500, gin.H{"error": "Failed to dislike comment"})
-		return
-	}
+	touchBoard(comment.ReceiverID)
 
 	c.JSON(200, gin.H{"message": "Comment disliked"})
 }
@@ -704,41 +942,36 @@ func removeDislike(c *gin.Context) {
 		return
 	}
 
-	var comment Comment
 	commentIDUint, err := strconv.ParseUint(commentID, 10, 64)
 	if err != nil {
 		c.JSON(400, gin.H{"error": "Invalid Comment ID"})
 		return
 	}
 
-	if err := db.Where(&Comment{ID: uint(commentIDUint)}).First(&comment).Error; err != nil {
+	comment, err := store.FindComment(uint(commentIDUint))
+	if err != nil {
 		c.JSON(404, gin.H{"error": "Comment not found"})
 		return
 	}
 
-	session := sessions.Default(c)
-	userID := session.Get("github_id")
-	if userID == nil {
-		c.JSON(401, gin.H{"error": "Unauthorized"})
-		return
-	}
+	gitHubUser := currentUser(c)
 
-	var gitHubUser GitHubUser
-	if err := db.Where(&GitHubUser{GitHubID: userID.(float64)}).First(&gitHubUser).Error; err != nil {
-		c.JSON(401, gin.H{"error": "Unauthorized"})
+	if !checkNotBlocked(c, comment.ReceiverID) {
 		return
 	}
 
-	if err := db.Where(&Disliked{CommentID: uint(commentIDUint), UserID: gitHubUser.ID}).First(&Disliked{}).Error; err != nil {
+	if disliked, _ := store.HasDisliked(comment.ID, gitHubUser.ID); !disliked {
 		c.JSON(400, gin.H{"error": "Comment not disliked"})
 		return
 	}
 
-	if err := db.Where(&Disliked{CommentID: uint(commentIDUint), UserID: gitHubUser.ID}).Delete(&Disliked{}).Error; err != nil {
+	if err := store.RemoveDislike(comment.ID, gitHubUser.ID); err != nil {
 		c.JSON(500, gin.H{"error": "Failed to remove dislike"})
 		return
 	}
 
+	touchBoard(comment.ReceiverID)
+
 	c.JSON(200, gin.H{"message": "Dislike removed"})
 }
 
@@ -755,24 +988,13 @@ func ownerLikeComment(c *gin.Context) {
 		return
 	}
 
-	var comment Comment
-	if err := db.Where(&Comment{ID: uint(commentIDUint)}).First(&comment).Error; err != nil {
+	comment, err := store.FindComment(uint(commentIDUint))
+	if err != nil {
 		c.JSON(404, gin.H{"error": "Comment not found"})
 		return
 	}
 
-	session := sessions.Default(c)
-	userID := session.Get("github_id")
-	if userID == nil {
-		c.JSON(401, gin.H{"error": "Unauthorized"})
-		return
-	}
-
-	var gitHubUser GitHubUser
-	if err := db.Where(&GitHubUser{GitHubID: userID.(float64)}).First(&gitHubUser).Error; err != nil {
-		c.JSON(401, gin.H{"error": "Unauthorized"})
-		return
-	}
+	gitHubUser := currentUser(c)
 
 	if comment.ReceiverID != gitHubUser.ID {
 		c.JSON(400, gin.H{"error": "You can only like your own comment"})
@@ -784,11 +1006,13 @@ func ownerLikeComment(c *gin.Context) {
 		return
 	}
 
-	if err := db.Model(&comment).Update("is_owner_liked", true).Error; err != nil {
+	if err := store.SetOwnerLiked(comment.ID, true); err != nil {
 		c.JSON(500, gin.H{"error": "Failed to like comment"})
 		return
 	}
 
+	touchBoard(comment.ReceiverID)
+
 	c.JSON(200, gin.H{"message": "Comment liked"})
 }
 
@@ -799,30 +1023,19 @@ func ownerRemoveLike(c *gin.Context) {
 		return
 	}
 
-	var comment Comment
 	commentIDUint, err := strconv.ParseUint(commentID, 10, 64)
 	if err != nil {
 		c.JSON(400, gin.H{"error": "Invalid Comment ID"})
 		return
 	}
 
-	if err := db.Where(&Comment{ID: uint(commentIDUint)}).First(&comment).Error; err != nil {
+	comment, err := store.FindComment(uint(commentIDUint))
+	if err != nil {
 		c.JSON(404, gin.H{"error": "Comment not found"})
 		return
 	}
 
-	session := sessions.Default(c)
-	userID := session.Get("github_id")
-	if userID == nil {
-		c.JSON(401, gin.H{"error": "Unauthorized"})
-		return
-	}
-
-	var gitHubUser GitHubUser
-	if err := db.Where(&GitHubUser{GitHubID: userID.(float64)}).First(&gitHubUser).Error; err != nil {
-		c.JSON(401, gin.H{"error": "Unauthorized"})
-		return
-	}
+	gitHubUser := currentUser(c)
 
 	if comment.ReceiverID != gitHubUser.ID {
 		c.JSON(400, gin.H{"error": "You can only remove like from your own comment"})
@@ -834,20 +1047,90 @@ func ownerRemoveLike(c *gin.Context) {
 		return
 	}
 
-	if err := db.Model(&comment).Update("is_owner_liked", false).Error; err != nil {
+	if err := store.SetOwnerLiked(comment.ID, false); err != nil {
 		c.JSON(500, gin.H{"error": "Failed to remove like"})
 		return
 	}
 
+	touchBoard(comment.ReceiverID)
+
 	c.JSON(200, gin.H{"message": "Like removed"})
 }
 
+// pinnedFirst orders comments by pin position, pinned before unpinned. ok is
+// false when neither is pinned and the caller's own ordering applies.
+func pinnedFirst(i, j int) (before, ok bool) {
+	if i == 0 && j == 0 {
+		return false, false
+	}
+	if i == 0 || j == 0 {
+		return i != 0, true
+	}
+	return i < j, true
+}
+
+// touchBoard records that a receiver's comment board changed, moving its
+// ETag revision forward and dropping any cached SVG renderings.
+func touchBoard(receiverID uint) {
+	receiver, err := store.TouchBoard(receiverID)
+	if err != nil {
+		fmt.Println("Error updating board revision:", err)
+		return
+	}
+	boardSVGCache.invalidate(receiver.GitHubLogin)
+}
+
+// notModified sets the ETag header and reports whether the request's
+// If-None-Match already names it, in which case a 304 has been sent.
+func notModified(c *gin.Context, etag string) bool {
+	c.Header("ETag", etag)
+	for _, candidate := range strings.Split(c.GetHeader("If-None-Match"), ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			c.AbortWithStatus(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+func cacheControl(scope string, maxAge Duration) string {
+	return fmt.Sprintf("%s, max-age=%d", scope, int(time.Duration(maxAge).Seconds()))
+}
+
 func generateStateString() string {
 	b := make([]byte, 32)
 	rand.Read(b)
 	return base64.URLEncoding.EncodeToString(b)
 }
 
+// sanitizeCommentContent applies the rules shared by new and edited
+// comments, returning the content as it should be stored and whether a
+// content filter flagged it for moderation.
+func sanitizeCommentContent(content string, filters []contentFilter) (string, bool, error) {
+	if content == "" {
+		return "", false, errors.New("Content not provided")
+	}
+
+	if len(content) > 35 {
+		runes := []rune(content)
+		if len(runes) > 35 {
+			content = string(runes[:35])
+		}
+	}
+
+	if hasZalgo(content) {
+		return "", false, errors.New("Invalid content")
+	}
+
+	content, flagged, err := filterContent(content, filters)
+	if err != nil {
+		return "", false, err
+	}
+
+	return escapeHTML(content), flagged, nil
+}
+
 func escapeHTML(text string) string {
 	return template.HTMLEscapeString(text)
 }
@@ -861,6 +1144,7 @@ func generateCommentBox(userName string, comments []SvgCommentModel, textColor,
 	const (
 		additionalHeightPerComment = 35
 		commentBoxMargin           = 5
+		replyIndent                = 20
 	)
 
 	numComments := len(comments)
@@ -875,8 +1159,17 @@ func generateCommentBox(userName string, comments []SvgCommentModel, textColor,
 	var commentBoxes []string
 	for i, comment := range comments {
 		commentY := 40 + i*additionalHeightPerComment
-		commentBox := fmt.Sprintf(`<rect x="%d" y="%d" width="%d" height="30" fill="%s" stroke="%s" rx="5" ry="5"/>`, commentBoxMargin, commentY, 540-2*commentBoxMargin, boxColor, textColor)
-		commentText := fmt.Sprintf(`<text x="%d" y="%d" font-family="Arial" font-size="14" fill="%s">%s: %s</text>`, commentBoxMargin*2, commentY+20, textColor, escapeHTML(comment.Author), comment.Content)
+		indent := comment.Depth * replyIndent
+		commentBox := fmt.Sprintf(`<rect x="%d" y="%d" width="%d" height="30" fill="%s" stroke="%s" rx="5" ry="5"/>`, commentBoxMargin+indent, commentY, 540-2*commentBoxMargin-indent, boxColor, textColor)
+		edited := ""
+		if comment.Edited {
+			edited = ` <tspan font-size="10" fill="gray">(edited)</tspan>`
+		}
+		pin := ""
+		if comment.PinnedPosition > 0 {
+			pin = "📌 "
+		}
+		commentText := fmt.Sprintf(`<text x="%d" y="%d" font-family="Arial" font-size="14" fill="%s">%s%s: %s%s</text>`, commentBoxMargin*2+indent, commentY+20, textColor, pin, escapeHTML(comment.Author), comment.Content, edited)
 		commentBoxes = append(commentBoxes, commentBox, commentText)
 	}
 
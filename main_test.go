@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+)
+
+// newTestRouter points the package globals at a fresh in-memory store and
+// returns the full router.
+func newTestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+	config = defaultConfig()
+	config.OriginURL = "http://localhost:8080"
+	config.SessionSecret = "test"
+	store = newMemoryStore()
+	sessionStore = cookie.NewStore([]byte(config.SessionSecret))
+	boardSVGCache = newSVGCache(time.Minute)
+	rateLimiter = newMemoryRateLimiter()
+	globalContentFilters = nil
+
+	return newRouter()
+}
+
+// newTestUser signs up login and returns a bearer token with every scope.
+func newTestUser(t *testing.T, githubID float64, login string) (GitHubUser, string) {
+	t.Helper()
+
+	user := mustSaveUser(t, store, githubID, login)
+	secret := generateAPIToken()
+	token := APIToken{UserID: user.ID, Name: "test", TokenHash: hashSecret(secret), Scopes: strings.Join(tokenScopes, ",")}
+	if err := store.CreateAPIToken(&token); err != nil {
+		t.Fatal(err)
+	}
+	return user, secret
+}
+
+func doRequest(t *testing.T, router http.Handler, method, path, token string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var reader io.Reader = http.NoBody
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func wantStatus(t *testing.T, w *httptest.ResponseRecorder, want int) {
+	t.Helper()
+
+	if w.Code != want {
+		t.Fatalf("status = %d, want %d; body %s", w.Code, want, w.Body.String())
+	}
+}
+
+func listComments(t *testing.T, router http.Handler, board, token string) []CommentResponse {
+	t.Helper()
+
+	w := doRequest(t, router, "GET", "/api/user/"+board+"/comments", token, nil)
+	wantStatus(t, w, 200)
+
+	var comments []CommentResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &comments); err != nil {
+		t.Fatal(err)
+	}
+	return comments
+}
+
+func TestCreateComment(t *testing.T) {
+	router := newTestRouter(t)
+	newTestUser(t, 1, "owner")
+	_, author := newTestUser(t, 2, "author")
+
+	w := doRequest(t, router, "POST", "/api/user/owner/comments", "", gin.H{"content": "hello"})
+	wantStatus(t, w, 401)
+
+	w = doRequest(t, router, "POST", "/api/user/nobody/comments", author, gin.H{"content": "hello"})
+	wantStatus(t, w, 404)
+
+	w = doRequest(t, router, "POST", "/api/user/owner/comments", author, gin.H{"content": ""})
+	wantStatus(t, w, 400)
+
+	w = doRequest(t, router, "POST", "/api/user/owner/comments", author, gin.H{"content": "<b>" + strings.Repeat("가", 40)})
+	wantStatus(t, w, 200)
+
+	w = doRequest(t, router, "POST", "/api/user/owner/comments", author, gin.H{"content": "again"})
+	wantStatus(t, w, 400)
+
+	comments := listComments(t, router, "owner", "")
+	if len(comments) != 1 {
+		t.Fatalf("got %d comments, want 1", len(comments))
+	}
+	if want := "&lt;b&gt;" + strings.Repeat("가", 32); comments[0].Content != want || comments[0].Author != "author" {
+		t.Errorf("comment = %+v, want escaped content truncated to 35 characters", comments[0])
+	}
+}
+
+func TestGetComments(t *testing.T) {
+	router := newTestRouter(t)
+	newTestUser(t, 1, "owner")
+	_, alice := newTestUser(t, 2, "alice")
+	_, bob := newTestUser(t, 3, "bob")
+
+	w := doRequest(t, router, "GET", "/api/user/nobody/comments", "", nil)
+	wantStatus(t, w, 404)
+
+	if comments := listComments(t, router, "owner", ""); len(comments) != 0 {
+		t.Fatalf("empty board listed %+v", comments)
+	}
+
+	wantStatus(t, doRequest(t, router, "POST", "/api/user/owner/comments", alice, gin.H{"content": "from alice"}), 200)
+	wantStatus(t, doRequest(t, router, "POST", "/api/user/owner/comments", bob, gin.H{"content": "from bob"}), 200)
+
+	comments := listComments(t, router, "owner", bob)
+	if len(comments) != 2 || comments[0].Author != "bob" {
+		t.Fatalf("bob's view = %+v, want his own comment first", comments)
+	}
+
+	id := comments[1].ID
+	wantStatus(t, doRequest(t, router, "POST", fmt.Sprintf("/api/like/like/%d", id), bob, nil), 200)
+
+	comments = listComments(t, router, "owner", bob)
+	if comments[1].Likes != 1 || !comments[1].IsLiked {
+		t.Errorf("bob's view of a comment he liked = %+v", comments[1])
+	}
+	comments = listComments(t, router, "owner", "")
+	if comments[0].Author != "alice" || comments[0].Likes != 1 || comments[0].IsLiked {
+		t.Errorf("anonymous view = %+v, want the liked comment first and not marked as liked", comments)
+	}
+}
+
+func TestLikeComment(t *testing.T) {
+	router := newTestRouter(t)
+	_, owner := newTestUser(t, 1, "owner")
+	_, author := newTestUser(t, 2, "author")
+	_, viewer := newTestUser(t, 3, "viewer")
+
+	wantStatus(t, doRequest(t, router, "POST", "/api/user/owner/comments", author, gin.H{"content": "hello"}), 200)
+	id := listComments(t, router, "owner", "")[0].ID
+	like := fmt.Sprintf("/api/like/like/%d", id)
+	dislike := fmt.Sprintf("/api/like/dislike/%d", id)
+
+	wantStatus(t, doRequest(t, router, "POST", like, "", nil), 401)
+	wantStatus(t, doRequest(t, router, "POST", "/api/like/like/0", viewer, nil), 404)
+	wantStatus(t, doRequest(t, router, "POST", fmt.Sprintf("/api/like/like/%d", id+100), viewer, nil), 404)
+	wantStatus(t, doRequest(t, router, "POST", "/api/like/like/abc", viewer, nil), 400)
+	wantStatus(t, doRequest(t, router, "POST", like, author, nil), 400)
+
+	wantStatus(t, doRequest(t, router, "POST", like, viewer, nil), 200)
+	wantStatus(t, doRequest(t, router, "POST", like, viewer, nil), 400)
+	wantStatus(t, doRequest(t, router, "POST", dislike, viewer, nil), 400)
+
+	wantStatus(t, doRequest(t, router, "POST", dislike, owner, nil), 200)
+	wantStatus(t, doRequest(t, router, "POST", like, owner, nil), 400)
+
+	comment := listComments(t, router, "owner", "")[0]
+	if comment.Likes != 1 || comment.Dislikes != 1 {
+		t.Fatalf("counts = %d likes, %d dislikes, want 1 and 1", comment.Likes, comment.Dislikes)
+	}
+
+	wantStatus(t, doRequest(t, router, "POST", fmt.Sprintf("/api/like/remove-like/%d", id), viewer, nil), 200)
+	wantStatus(t, doRequest(t, router, "POST", fmt.Sprintf("/api/like/remove-like/%d", id), viewer, nil), 400)
+	wantStatus(t, doRequest(t, router, "POST", dislike, viewer, nil), 200)
+
+	comment = listComments(t, router, "owner", "")[0]
+	if comment.Likes != 0 || comment.Dislikes != 2 {
+		t.Errorf("counts = %d likes, %d dislikes, want 0 and 2", comment.Likes, comment.Dislikes)
+	}
+}
+
+func TestDeleteComment(t *testing.T) {
+	router := newTestRouter(t)
+	newTestUser(t, 1, "owner")
+	_, author := newTestUser(t, 2, "author")
+	otherUser, other := newTestUser(t, 3, "other")
+
+	wantStatus(t, doRequest(t, router, "DELETE", "/api/user/owner/comments", author, nil), 404)
+
+	wantStatus(t, doRequest(t, router, "POST", "/api/user/owner/comments", author, gin.H{"content": "hello"}), 200)
+	wantStatus(t, doRequest(t, router, "POST", "/api/user/owner/comments", other, gin.H{"content": "stays"}), 200)
+	id := listComments(t, router, "owner", author)[0].ID
+	wantStatus(t, doRequest(t, router, "POST", fmt.Sprintf("/api/like/like/%d", id), other, nil), 200)
+
+	wantStatus(t, doRequest(t, router, "DELETE", "/api/user/owner/comments", "", nil), 401)
+	wantStatus(t, doRequest(t, router, "DELETE", "/api/user/owner/comments", author, nil), 200)
+	wantStatus(t, doRequest(t, router, "DELETE", "/api/user/owner/comments", author, nil), 404)
+
+	comments := listComments(t, router, "owner", "")
+	if len(comments) != 1 || comments[0].Author != "other" {
+		t.Fatalf("after delete = %+v", comments)
+	}
+	if liked, _ := store.HasLiked(id, otherUser.ID); liked {
+		t.Error("the deleted comment kept its likes")
+	}
+
+	wantStatus(t, doRequest(t, router, "POST", "/api/user/owner/comments", author, gin.H{"content": "back"}), 200)
+}
+
+func TestGetCommentSVGIgnoresLoginCase(t *testing.T) {
+	router := newTestRouter(t)
+	newTestUser(t, 1, "Owner")
+	_, author := newTestUser(t, 2, "author")
+
+	get := func(login string) string {
+		t.Helper()
+		w := doRequest(t, router, "GET", "/api/user/"+login+"/svg", "", nil)
+		wantStatus(t, w, 200)
+		return w.Body.String()
+	}
+
+	// Cold cache: the first request for the board uses a different case.
+	if svg := get("OWNER"); !strings.Contains(svg, "Owner") {
+		t.Fatalf("card for OWNER does not show the stored login:\n%s", svg)
+	}
+	// Warm cache: every spelling is served the same card.
+	for _, login := range []string{"owner", "Owner", "oWnEr"} {
+		get(login)
+	}
+
+	wantStatus(t, doRequest(t, router, "POST", "/api/user/owner/comments", author, gin.H{"content": "hello"}), 200)
+	if svg := get("OWNER"); !strings.Contains(svg, "hello") {
+		t.Errorf("card after a new comment is stale:\n%s", svg)
+	}
+
+	boardSVGCache = newSVGCache(0)
+	for _, login := range []string{"OWNER", "owner"} {
+		get(login)
+	}
+	wantStatus(t, doRequest(t, router, "GET", "/api/user/nobody/svg", "", nil), 404)
+}
+
+func TestReportComment(t *testing.T) {
+	router := newTestRouter(t)
+	config.ReportHideThreshold = 2
+	_, owner := newTestUser(t, 1, "owner")
+	_, author := newTestUser(t, 2, "author")
+	_, first := newTestUser(t, 3, "first")
+	_, second := newTestUser(t, 4, "second")
+
+	wantStatus(t, doRequest(t, router, "POST", "/api/user/owner/comments", author, gin.H{"content": "reported"}), 200)
+	wantStatus(t, doRequest(t, router, "POST", "/api/user/owner/comments", first, gin.H{"content": "hidden"}), 200)
+	wantStatus(t, doRequest(t, router, "POST", "/api/user/owner/comments", second, gin.H{"content": "pending"}), 200)
+	ids := make(map[string]uint)
+	for _, comment := range listComments(t, router, "owner", "") {
+		ids[comment.Content] = comment.ID
+	}
+	report := func(content, token string) *httptest.ResponseRecorder {
+		return doRequest(t, router, "POST", fmt.Sprintf("/api/comments/%d/report", ids[content]), token, gin.H{"reason": "spam"})
+	}
+
+	wantStatus(t, doRequest(t, router, "POST", fmt.Sprintf("/api/user/owner/hidden/%d", ids["hidden"]), owner, nil), 200)
+	if err := store.SetCommentPending(ids["pending"], true); err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, report("hidden", second), 404)
+	wantStatus(t, report("pending", first), 404)
+
+	wantStatus(t, report("reported", author), 400)
+	wantStatus(t, report("reported", first), 201)
+	wantStatus(t, report("reported", first), 400)
+
+	svg := doRequest(t, router, "GET", "/api/user/owner/svg", "", nil)
+	wantStatus(t, svg, 200)
+	if !strings.Contains(svg.Body.String(), "reported") {
+		t.Fatal("comment left the card before reaching the threshold")
+	}
+
+	wantStatus(t, report("reported", second), 201)
+	svg = doRequest(t, router, "GET", "/api/user/owner/svg", "", nil)
+	wantStatus(t, svg, 200)
+	if strings.Contains(svg.Body.String(), "reported") {
+		t.Error("card still shows a comment that reached the report threshold")
+	}
+}
+
+func TestSafeReturnPath(t *testing.T) {
+	tests := []struct {
+		raw    string
+		want   string
+		wantOK bool
+	}{
+		{"/", "/", true},
+		{"/owner", "/owner", true},
+		{"/in-jun", "/in-jun", true},
+		{"/A1", "/A1", true},
+		{"/" + strings.Repeat("a", 39), "/" + strings.Repeat("a", 39), true},
+		{"/owner?tab=1#top", "/owner", true},
+		{"", "", false},
+		{"owner", "", false},
+		{"//evil.com", "", false},
+		{"///evil.com", "", false},
+		{`/\evil.com`, "", false},
+		{`\\evil.com`, "", false},
+		{"https://evil.com", "", false},
+		{"/%2F%2Fevil.com", "", false},
+		{"/%5Cevil.com", "", false},
+		{"javascript:alert(1)", "", false},
+		{"/javascript:alert(1)", "", false},
+		{"/-owner", "", false},
+		{"/" + strings.Repeat("a", 40), "", false},
+		{"/owner/", "", false},
+		{"/owner/settings", "", false},
+		{"/api/tokens", "", false},
+		{"/../evil.com", "", false},
+		{"/owner\nLocation: https://evil.com", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := safeReturnPath(tt.raw)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("safeReturnPath(%q) = %q, %v, want %q, %v", tt.raw, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
@@ -0,0 +1,516 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// migration is one numbered schema change. Up and Down receive a transaction
+// and must only reference table snapshots local to the migration, never the
+// current models, so that old migrations keep producing the same schema.
+type migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+type schemaMigration struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+type migrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// schemaMigrator is implemented by stores that keep a versioned schema.
+type schemaMigrator interface {
+	MigrationStatus() ([]migrationStatus, error)
+	MigrateDown(steps int) error
+}
+
+var migrations = []migration{
+	{
+		Version: 1,
+		Name:    "create_tables",
+		Up: func(tx *gorm.DB) error {
+			type gitHubUser struct {
+				ID          uint `gorm:"primary_key"`
+				GitHubID    float64
+				GitHubLogin string
+			}
+			type comment struct {
+				ID           uint `gorm:"primary_key"`
+				ReceiverID   uint
+				AuthorID     uint
+				Content      string
+				IsOwnerLiked bool
+			}
+			type reaction struct {
+				ID        uint `gorm:"primary_key"`
+				CommentID uint
+				UserID    uint
+			}
+
+			if err := tx.Table("git_hub_users").AutoMigrate(&gitHubUser{}); err != nil {
+				return err
+			}
+			if err := tx.Table("comments").AutoMigrate(&comment{}); err != nil {
+				return err
+			}
+			if err := tx.Table("likeds").AutoMigrate(&reaction{}); err != nil {
+				return err
+			}
+			return tx.Table("dislikeds").AutoMigrate(&reaction{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("dislikeds", "likeds", "comments", "git_hub_users")
+		},
+	},
+	{
+		Version: 2,
+		Name:    "add_unique_indexes",
+		Up: func(tx *gorm.DB) error {
+			// Point everything owned by a duplicate user at the oldest row
+			// for the same GitHub account before the duplicates go away.
+			// Comments and reactions that collide as a result are removed
+			// by the dedupe below.
+			references := []struct{ table, column string }{
+				{"comments", "author_id"},
+				{"comments", "receiver_id"},
+				{"likeds", "user_id"},
+				{"dislikeds", "user_id"},
+			}
+			for _, r := range references {
+				sql := fmt.Sprintf(`UPDATE %[1]s SET %[2]s = (SELECT MIN(keep.id) FROM git_hub_users AS keep, git_hub_users AS dup WHERE dup.id = %[1]s.%[2]s AND keep.git_hub_id = dup.git_hub_id)
+					WHERE %[2]s IN (SELECT dup.id FROM git_hub_users AS keep, git_hub_users AS dup WHERE keep.git_hub_id = dup.git_hub_id AND keep.id < dup.id)`, r.table, r.column)
+				if err := tx.Exec(sql).Error; err != nil {
+					return err
+				}
+			}
+
+			dedupe := []struct{ table, columns string }{
+				{"git_hub_users", "git_hub_id"},
+				{"comments", "receiver_id, author_id"},
+				{"likeds", "comment_id, user_id"},
+				{"dislikeds", "comment_id, user_id"},
+			}
+			for _, d := range dedupe {
+				// The derived table is required by MySQL, which refuses to
+				// select from the table a DELETE is modifying.
+				sql := fmt.Sprintf("DELETE FROM %[1]s WHERE id NOT IN (SELECT id FROM (SELECT MIN(id) AS id FROM %[1]s GROUP BY %[2]s) AS keep)", d.table, d.columns)
+				if err := tx.Exec(sql).Error; err != nil {
+					return err
+				}
+			}
+
+			for _, table := range []string{"likeds", "dislikeds"} {
+				if err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE comment_id NOT IN (SELECT id FROM comments)", table)).Error; err != nil {
+					return err
+				}
+			}
+
+			indexes := []string{
+				"CREATE UNIQUE INDEX idx_git_hub_users_git_hub_id ON git_hub_users (git_hub_id)",
+				"CREATE UNIQUE INDEX idx_comments_receiver_author ON comments (receiver_id, author_id)",
+				"CREATE UNIQUE INDEX idx_likeds_comment_user ON likeds (comment_id, user_id)",
+				"CREATE UNIQUE INDEX idx_dislikeds_comment_user ON dislikeds (comment_id, user_id)",
+			}
+			for _, sql := range indexes {
+				if err := tx.Exec(sql).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			indexes := []struct{ table, name string }{
+				{"dislikeds", "idx_dislikeds_comment_user"},
+				{"likeds", "idx_likeds_comment_user"},
+				{"comments", "idx_comments_receiver_author"},
+				{"git_hub_users", "idx_git_hub_users_git_hub_id"},
+			}
+			for _, index := range indexes {
+				if err := tx.Migrator().DropIndex(index.table, index.name); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 3,
+		Name:    "add_comment_reaction_counts",
+		Up: func(tx *gorm.DB) error {
+			type comment struct {
+				LikeCount    int `gorm:"not null;default:0"`
+				DislikeCount int `gorm:"not null;default:0"`
+			}
+			for _, field := range []string{"LikeCount", "DislikeCount"} {
+				if err := tx.Table("comments").Migrator().AddColumn(&comment{}, field); err != nil {
+					return err
+				}
+			}
+			_, err := recountReactions(tx)
+			return err
+		},
+		Down: func(tx *gorm.DB) error {
+			for _, column := range []string{"like_count", "dislike_count"} {
+				if err := tx.Exec("ALTER TABLE comments DROP COLUMN " + column).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 4,
+		Name:    "add_board_revision",
+		Up: func(tx *gorm.DB) error {
+			type gitHubUser struct {
+				BoardRevision int64 `gorm:"not null;default:0"`
+			}
+			return tx.Table("git_hub_users").Migrator().AddColumn(&gitHubUser{}, "BoardRevision")
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Exec("ALTER TABLE git_hub_users DROP COLUMN board_revision").Error
+		},
+	},
+	{
+		Version: 5,
+		Name:    "create_api_tokens",
+		Up: func(tx *gorm.DB) error {
+			type apiToken struct {
+				ID         uint `gorm:"primary_key"`
+				UserID     uint `gorm:"index:idx_api_tokens_user_id"`
+				Name       string
+				TokenHash  string `gorm:"size:64;uniqueIndex:idx_api_tokens_token_hash"`
+				Scopes     string
+				CreatedAt  time.Time
+				LastUsedAt *time.Time
+			}
+			return tx.Table("api_tokens").AutoMigrate(&apiToken{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("api_tokens")
+		},
+	},
+	{
+		Version: 6,
+		Name:    "create_user_sessions",
+		Up: func(tx *gorm.DB) error {
+			type userSession struct {
+				ID         uint   `gorm:"primary_key"`
+				UserID     uint   `gorm:"index:idx_user_sessions_user_id"`
+				TokenHash  string `gorm:"size:64;uniqueIndex:idx_user_sessions_token_hash"`
+				UserAgent  string `gorm:"size:255"`
+				IPAddress  string `gorm:"size:64"`
+				CreatedAt  time.Time
+				LastSeenAt time.Time
+				ExpiresAt  time.Time `gorm:"index:idx_user_sessions_expires_at"`
+			}
+			return tx.Table("user_sessions").AutoMigrate(&userSession{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("user_sessions")
+		},
+	},
+	{
+		Version: 7,
+		Name:    "add_comment_timestamps",
+		Up: func(tx *gorm.DB) error {
+			type comment struct {
+				CreatedAt time.Time
+				UpdatedAt time.Time
+			}
+			for _, field := range []string{"CreatedAt", "UpdatedAt"} {
+				if err := tx.Table("comments").Migrator().AddColumn(&comment{}, field); err != nil {
+					return err
+				}
+			}
+			// Existing comments never recorded when they were written, so
+			// they are stamped with the migration time and not marked edited.
+			now := time.Now()
+			return tx.Exec("UPDATE comments SET created_at = ?, updated_at = ?", now, now).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			for _, column := range []string{"created_at", "updated_at"} {
+				if err := tx.Exec("ALTER TABLE comments DROP COLUMN " + column).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 8,
+		Name:    "add_comment_pinned_position",
+		Up: func(tx *gorm.DB) error {
+			type comment struct {
+				PinnedPosition int `gorm:"not null;default:0"`
+			}
+			return tx.Table("comments").Migrator().AddColumn(&comment{}, "PinnedPosition")
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Exec("ALTER TABLE comments DROP COLUMN pinned_position").Error
+		},
+	},
+	{
+		Version: 9,
+		Name:    "add_comment_replies",
+		Up: func(tx *gorm.DB) error {
+			type comment struct {
+				ParentID uint `gorm:"not null;default:0"`
+			}
+			if err := tx.Table("comments").Migrator().AddColumn(&comment{}, "ParentID"); err != nil {
+				return err
+			}
+			if err := tx.Migrator().DropIndex("comments", "idx_comments_receiver_author"); err != nil {
+				return err
+			}
+			return tx.Exec("CREATE UNIQUE INDEX idx_comments_receiver_author_parent ON comments (receiver_id, author_id, parent_id)").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			// Replies cannot be represented without parent_id, so they are
+			// dropped along with their reactions.
+			for _, table := range []string{"likeds", "dislikeds"} {
+				if err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE comment_id IN (SELECT id FROM comments WHERE parent_id <> 0)", table)).Error; err != nil {
+					return err
+				}
+			}
+			if err := tx.Exec("DELETE FROM comments WHERE parent_id <> 0").Error; err != nil {
+				return err
+			}
+			if err := tx.Migrator().DropIndex("comments", "idx_comments_receiver_author_parent"); err != nil {
+				return err
+			}
+			if err := tx.Exec("CREATE UNIQUE INDEX idx_comments_receiver_author ON comments (receiver_id, author_id)").Error; err != nil {
+				return err
+			}
+			return tx.Exec("ALTER TABLE comments DROP COLUMN parent_id").Error
+		},
+	},
+	{
+		Version: 10,
+		Name:    "add_comment_moderation",
+		Up: func(tx *gorm.DB) error {
+			type comment struct {
+				Hidden bool `gorm:"not null;default:false"`
+			}
+			type moderationAction struct {
+				ID          uint `gorm:"primary_key"`
+				ReceiverID  uint `gorm:"index:idx_moderation_actions_receiver_id"`
+				ModeratorID uint
+				CommentID   uint
+				AuthorID    uint
+				Action      string `gorm:"size:16"`
+				Content     string
+				CreatedAt   time.Time
+			}
+			if err := tx.Table("comments").Migrator().AddColumn(&comment{}, "Hidden"); err != nil {
+				return err
+			}
+			return tx.Table("moderation_actions").AutoMigrate(&moderationAction{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropTable("moderation_actions"); err != nil {
+				return err
+			}
+			return tx.Exec("ALTER TABLE comments DROP COLUMN hidden").Error
+		},
+	},
+	{
+		Version: 11,
+		Name:    "create_blocks",
+		Up: func(tx *gorm.DB) error {
+			type block struct {
+				ID         uint `gorm:"primary_key"`
+				ReceiverID uint `gorm:"uniqueIndex:idx_blocks_receiver_blocked"`
+				BlockedID  uint `gorm:"uniqueIndex:idx_blocks_receiver_blocked"`
+				CreatedAt  time.Time
+			}
+			return tx.Table("blocks").AutoMigrate(&block{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("blocks")
+		},
+	},
+	{
+		Version: 12,
+		Name:    "add_comment_approval",
+		Up: func(tx *gorm.DB) error {
+			type gitHubUser struct {
+				RequireApproval bool `gorm:"not null;default:false"`
+			}
+			type comment struct {
+				Pending bool `gorm:"not null;default:false"`
+			}
+			if err := tx.Table("git_hub_users").Migrator().AddColumn(&gitHubUser{}, "RequireApproval"); err != nil {
+				return err
+			}
+			return tx.Table("comments").Migrator().AddColumn(&comment{}, "Pending")
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Exec("ALTER TABLE comments DROP COLUMN pending").Error; err != nil {
+				return err
+			}
+			return tx.Exec("ALTER TABLE git_hub_users DROP COLUMN require_approval").Error
+		},
+	},
+	{
+		Version: 13,
+		Name:    "create_filter_rules",
+		Up: func(tx *gorm.DB) error {
+			type filterRule struct {
+				ID         uint   `gorm:"primary_key"`
+				ReceiverID uint   `gorm:"index:idx_filter_rules_receiver_id"`
+				Pattern    string `gorm:"size:100"`
+				Regex      bool   `gorm:"not null;default:false"`
+				Action     string `gorm:"size:16"`
+				CreatedAt  time.Time
+			}
+			return tx.Table("filter_rules").AutoMigrate(&filterRule{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("filter_rules")
+		},
+	},
+	{
+		Version: 14,
+		Name:    "create_reports",
+		Up: func(tx *gorm.DB) error {
+			type comment struct {
+				ReportCount int `gorm:"not null;default:0"`
+			}
+			type report struct {
+				ID         uint   `gorm:"primary_key"`
+				CommentID  uint   `gorm:"uniqueIndex:idx_reports_comment_reporter"`
+				ReporterID uint   `gorm:"uniqueIndex:idx_reports_comment_reporter"`
+				Reason     string `gorm:"size:32"`
+				Status     string `gorm:"size:16;index:idx_reports_status"`
+				CreatedAt  time.Time
+			}
+			if err := tx.Table("comments").Migrator().AddColumn(&comment{}, "ReportCount"); err != nil {
+				return err
+			}
+			return tx.Table("reports").AutoMigrate(&report{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropTable("reports"); err != nil {
+				return err
+			}
+			return tx.Exec("ALTER TABLE comments DROP COLUMN report_count").Error
+		},
+	},
+	{
+		Version: 15,
+		Name:    "create_rate_limit_buckets",
+		Up: func(tx *gorm.DB) error {
+			type rateLimitBucket struct {
+				Key       string `gorm:"primaryKey;size:191"`
+				Tokens    float64
+				UpdatedAt time.Time
+				FullAt    time.Time `gorm:"index:idx_rate_limit_buckets_full_at"`
+			}
+			return tx.Table("rate_limit_buckets").AutoMigrate(&rateLimitBucket{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("rate_limit_buckets")
+		},
+	},
+}
+
+func (s *gormStore) appliedMigrations() (map[int]schemaMigration, error) {
+	if err := s.db.AutoMigrate(&schemaMigration{}); err != nil {
+		return nil, err
+	}
+
+	var rows []schemaMigration
+	if err := s.db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]schemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// Migrate applies every pending migration in version order.
+func (s *gormStore) Migrate() error {
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&schemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
+
+// MigrateDown reverts the most recently applied migrations.
+func (s *gormStore) MigrateDown(steps int) error {
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return err
+	}
+
+	for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&schemaMigration{Version: m.Version}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("reverting migration %d %s: %w", m.Version, m.Name, err)
+		}
+		steps--
+	}
+	return nil
+}
+
+func (s *gormStore) MigrationStatus() ([]migrationStatus, error) {
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]migrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status := migrationStatus{Version: m.Version, Name: m.Name}
+		if row, ok := applied[m.Version]; ok {
+			appliedAt := row.AppliedAt
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
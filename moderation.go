@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	moderationHide    = "hide"
+	moderationUnhide  = "unhide"
+	moderationDelete  = "delete"
+	moderationApprove = "approve"
+	moderationReject  = "reject"
+)
+
+// moderationLogLimit caps how many of the most recent actions the audit
+// log endpoint returns.
+const moderationLogLimit = 100
+
+// ModerationAction is an audit record of an owner acting on a comment on
+// their board. The comment's author and content are copied so the record
+// survives the comment being deleted.
+type ModerationAction struct {
+	ID          uint `gorm:"primary_key"`
+	ReceiverID  uint
+	ModeratorID uint
+	CommentID   uint
+	AuthorID    uint
+	Action      string
+	Content     string
+	CreatedAt   time.Time
+}
+
+type ModerationActionResponse struct {
+	ID        uint      `json:"id"`
+	CommentID uint      `json:"comment_id"`
+	Author    string    `json:"author"`
+	Moderator string    `json:"moderator"`
+	Action    string    `json:"action"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// boardComment resolves :commentID on a board the caller owns. ok is false
+// once an error response has been written.
+func boardComment(c *gin.Context) (GitHubUser, Comment, bool) {
+	receiver, ok := boardOwner(c)
+	if !ok {
+		return GitHubUser{}, Comment{}, false
+	}
+
+	commentID, err := strconv.ParseUint(c.Param("commentID"), 10, 64)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid Comment ID"})
+		return GitHubUser{}, Comment{}, false
+	}
+
+	comment, err := store.FindComment(uint(commentID))
+	if err != nil || comment.ReceiverID != receiver.ID {
+		c.JSON(404, gin.H{"error": "Comment not found"})
+		return GitHubUser{}, Comment{}, false
+	}
+	return receiver, comment, true
+}
+
+func recordModeration(c *gin.Context, comment Comment, action string) {
+	err := store.CreateModerationAction(&ModerationAction{
+		ReceiverID:  comment.ReceiverID,
+		ModeratorID: currentUser(c).ID,
+		CommentID:   comment.ID,
+		AuthorID:    comment.AuthorID,
+		Action:      action,
+		Content:     comment.Content,
+	})
+	if err != nil {
+		fmt.Println("Error recording moderation action:", err)
+	}
+}
+
+func hideComment(c *gin.Context) {
+	setCommentHidden(c, true)
+}
+
+func unhideComment(c *gin.Context) {
+	setCommentHidden(c, false)
+}
+
+func setCommentHidden(c *gin.Context, hidden bool) {
+	receiver, comment, ok := boardComment(c)
+	if !ok {
+		return
+	}
+
+	if comment.Hidden == hidden {
+		if hidden {
+			c.JSON(400, gin.H{"error": "Comment is already hidden"})
+		} else {
+			c.JSON(400, gin.H{"error": "Comment is not hidden"})
+		}
+		return
+	}
+
+	if err := store.SetCommentHidden(comment.ID, hidden); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to update comment"})
+		return
+	}
+
+	action, message := moderationUnhide, "Comment unhidden"
+	if hidden {
+		action, message = moderationHide, "Comment hidden"
+	}
+	recordModeration(c, comment, action)
+	touchBoard(receiver.ID)
+
+	c.JSON(200, gin.H{"message": message})
+}
+
+func moderateDeleteComment(c *gin.Context) {
+	receiver, comment, ok := boardComment(c)
+	if !ok {
+		return
+	}
+
+	if err := store.DeleteComment(comment.ID); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to delete comment"})
+		return
+	}
+
+	recordModeration(c, comment, moderationDelete)
+	touchBoard(receiver.ID)
+
+	c.JSON(200, gin.H{"message": "Comment deleted"})
+}
+
+func listModerationActions(c *gin.Context) {
+	receiver, ok := boardOwner(c)
+	if !ok {
+		return
+	}
+
+	actions, err := store.ListModerationActions(receiver.ID, moderationLogLimit)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to get moderation log"})
+		return
+	}
+
+	logins := make(map[uint]string)
+	login := func(id uint) string {
+		if name, ok := logins[id]; ok {
+			return name
+		}
+		if user, err := store.FindUser(id); err == nil {
+			logins[id] = user.GitHubLogin
+		}
+		return logins[id]
+	}
+
+	responses := make([]ModerationActionResponse, 0, len(actions))
+	for _, action := range actions {
+		responses = append(responses, ModerationActionResponse{
+			ID:        action.ID,
+			CommentID: action.CommentID,
+			Author:    login(action.AuthorID),
+			Moderator: login(action.ModeratorID),
+			Action:    action.Action,
+			Content:   action.Content,
+			CreatedAt: action.CreatedAt,
+		})
+	}
+	c.JSON(200, responses)
+}
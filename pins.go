@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxPinnedComments is how many comments an owner can pin to the top of
+// their board. Pinned comments have a PinnedPosition counting from 1 in
+// display order; unpinned comments have 0.
+const maxPinnedComments = 3
+
+// boardOwner resolves the :username board and checks that the caller owns
+// it. ok is false once an error response has been written.
+func boardOwner(c *gin.Context) (GitHubUser, bool) {
+	receiver, err := store.FindUserByLogin(c.Param("username"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "GitHub user not found"})
+		return GitHubUser{}, false
+	}
+
+	if currentUser(c).ID != receiver.ID {
+		c.JSON(403, gin.H{"error": "Only the profile owner can do this"})
+		return GitHubUser{}, false
+	}
+	return receiver, true
+}
+
+func pinComment(c *gin.Context) {
+	receiver, ok := boardOwner(c)
+	if !ok {
+		return
+	}
+
+	commentID, err := strconv.ParseUint(c.Param("commentID"), 10, 64)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid Comment ID"})
+		return
+	}
+
+	comment, err := store.FindComment(uint(commentID))
+	if err != nil || comment.ReceiverID != receiver.ID {
+		c.JSON(404, gin.H{"error": "Comment not found"})
+		return
+	}
+
+	if comment.ParentID != 0 {
+		c.JSON(400, gin.H{"error": "Only top-level comments can be pinned"})
+		return
+	}
+
+	if comment.Pending {
+		c.JSON(400, gin.H{"error": "Approve the comment before pinning it"})
+		return
+	}
+
+	pins, err := store.PinnedCommentIDs(receiver.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to get pinned comments"})
+		return
+	}
+
+	for _, id := range pins {
+		if id == comment.ID {
+			c.JSON(400, gin.H{"error": "Comment is already pinned"})
+			return
+		}
+	}
+	if len(pins) >= maxPinnedComments {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("You can pin at most %d comments", maxPinnedComments)})
+		return
+	}
+
+	if err := store.SetPinnedComments(receiver.ID, append(pins, comment.ID)); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to pin comment"})
+		return
+	}
+
+	touchBoard(receiver.ID)
+
+	c.JSON(200, gin.H{"message": "Comment pinned"})
+}
+
+func unpinComment(c *gin.Context) {
+	receiver, ok := boardOwner(c)
+	if !ok {
+		return
+	}
+
+	commentID, err := strconv.ParseUint(c.Param("commentID"), 10, 64)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid Comment ID"})
+		return
+	}
+
+	pins, err := store.PinnedCommentIDs(receiver.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to get pinned comments"})
+		return
+	}
+
+	remaining := make([]uint, 0, len(pins))
+	for _, id := range pins {
+		if id != uint(commentID) {
+			remaining = append(remaining, id)
+		}
+	}
+	if len(remaining) == len(pins) {
+		c.JSON(400, gin.H{"error": "Comment is not pinned"})
+		return
+	}
+
+	if err := store.SetPinnedComments(receiver.ID, remaining); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to unpin comment"})
+		return
+	}
+
+	touchBoard(receiver.ID)
+
+	c.JSON(200, gin.H{"message": "Comment unpinned"})
+}
+
+// reorderPins takes the currently pinned comment IDs in their new order.
+func reorderPins(c *gin.Context) {
+	receiver, ok := boardOwner(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		CommentIDs []uint `json:"comment_ids"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	pins, err := store.PinnedCommentIDs(receiver.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to get pinned comments"})
+		return
+	}
+
+	if err := samePins(pins, req.CommentIDs); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := store.SetPinnedComments(receiver.ID, req.CommentIDs); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to reorder pinned comments"})
+		return
+	}
+
+	touchBoard(receiver.ID)
+
+	c.JSON(200, gin.H{"message": "Pinned comments reordered"})
+}
+
+func samePins(pins, order []uint) error {
+	if len(order) != len(pins) {
+		return errors.New("comment_ids must list every pinned comment exactly once")
+	}
+
+	pinned := make(map[uint]bool, len(pins))
+	for _, id := range pins {
+		pinned[id] = true
+	}
+	for _, id := range order {
+		if !pinned[id] {
+			return errors.New("comment_ids must list every pinned comment exactly once")
+		}
+		delete(pinned, id)
+	}
+	return nil
+}
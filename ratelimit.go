@@ -0,0 +1,258 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// rateLimitSweepInterval is how often a limiter drops buckets that have
+// refilled completely and so carry no state.
+const rateLimitSweepInterval = time.Minute
+
+var rateLimiter RateLimiter = newMemoryRateLimiter()
+
+// RateLimiter takes one token from each of the buckets checked, but only
+// when every one of them has a token to give, so a request refused by one
+// bucket costs nothing from the others. When refused, retryAfter is how long
+// the caller has to wait for all of them to allow it.
+type RateLimiter interface {
+	Take(checks []rateLimitCheck, now time.Time) (ok bool, retryAfter time.Duration, err error)
+}
+
+// rateLimitCheck is one bucket a request is charged against.
+type rateLimitCheck struct {
+	Key   string
+	Limit RateLimit
+}
+
+// rateLimitBucket is a token bucket as of UpdatedAt. FullAt is when it will
+// have refilled to capacity, after which it can be forgotten.
+type rateLimitBucket struct {
+	Tokens    float64
+	UpdatedAt time.Time
+	FullAt    time.Time
+}
+
+// take refills b for the time since it was last updated and takes a token
+// from it if there is one. A zero bucket is treated as full. The returned
+// bucket only has a token fewer when ok is true.
+func (b rateLimitBucket) take(limit RateLimit, now time.Time) (rateLimitBucket, bool, time.Duration) {
+	interval := limit.Per / time.Duration(limit.Requests)
+	capacity := float64(limit.Requests)
+
+	tokens := capacity
+	if !b.UpdatedAt.IsZero() {
+		tokens = math.Min(capacity, b.Tokens+float64(now.Sub(b.UpdatedAt))/float64(interval))
+	}
+
+	ok := tokens >= 1
+	var retryAfter time.Duration
+	if ok {
+		tokens--
+	} else {
+		retryAfter = time.Duration((1 - tokens) * float64(interval))
+	}
+
+	return rateLimitBucket{
+		Tokens:    tokens,
+		UpdatedAt: now,
+		FullAt:    now.Add(time.Duration((capacity - tokens) * float64(interval))),
+	}, ok, retryAfter
+}
+
+// memoryRateLimiter keeps buckets in process, which is right for a single
+// instance.
+type memoryRateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]rateLimitBucket
+	lastSweep time.Time
+}
+
+func newMemoryRateLimiter() *memoryRateLimiter {
+	return &memoryRateLimiter{buckets: make(map[string]rateLimitBucket)}
+}
+
+func (rl *memoryRateLimiter) Take(checks []rateLimitCheck, now time.Time) (bool, time.Duration, error) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if now.Sub(rl.lastSweep) > rateLimitSweepInterval {
+		for k, bucket := range rl.buckets {
+			if now.After(bucket.FullAt) {
+				delete(rl.buckets, k)
+			}
+		}
+		rl.lastSweep = now
+	}
+
+	buckets := make([]rateLimitBucket, len(checks))
+	for i, check := range checks {
+		buckets[i] = rl.buckets[check.Key]
+	}
+	taken, ok, retryAfter := takeAll(checks, buckets, now)
+	if ok {
+		for i, check := range checks {
+			rl.buckets[check.Key] = taken[i]
+		}
+	}
+	return ok, retryAfter, nil
+}
+
+// takeAll takes a token from each of buckets, the current state of checks,
+// returning the buckets to store if every one of them had a token and the
+// longest wait otherwise.
+func takeAll(checks []rateLimitCheck, buckets []rateLimitBucket, now time.Time) ([]rateLimitBucket, bool, time.Duration) {
+	taken := make([]rateLimitBucket, len(checks))
+	allowed := true
+	var retryAfter time.Duration
+	for i, check := range checks {
+		bucket, ok, wait := buckets[i].take(check.Limit, now)
+		taken[i] = bucket
+		if !ok {
+			allowed = false
+			retryAfter = max(retryAfter, wait)
+		}
+	}
+	return taken, allowed, retryAfter
+}
+
+// RateLimitBucket is a bucket row shared by every instance using the same
+// database.
+type RateLimitBucket struct {
+	Key       string `gorm:"primaryKey"`
+	Tokens    float64
+	UpdatedAt time.Time `gorm:"autoUpdateTime:false"`
+	FullAt    time.Time
+}
+
+// gormRateLimiter keeps buckets in the database so that limits hold across
+// instances.
+type gormRateLimiter struct {
+	db        *gorm.DB
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+func (rl *gormRateLimiter) Take(checks []rateLimitCheck, now time.Time) (bool, time.Duration, error) {
+	rl.sweep(now)
+
+	var (
+		ok         bool
+		retryAfter time.Duration
+	)
+	err := rl.db.Transaction(func(tx *gorm.DB) error {
+		keys := make([]string, len(checks))
+		for i, check := range checks {
+			keys[i] = check.Key
+		}
+		// Rows are locked in key order so that two requests sharing
+		// buckets can't deadlock.
+		var rows []RateLimitBucket
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where(map[string]any{"key": keys}).
+			Order(clause.OrderByColumn{Column: clause.Column{Name: "key"}}).
+			Find(&rows).Error
+		if err != nil {
+			return err
+		}
+
+		stored := make(map[string]rateLimitBucket, len(rows))
+		for _, row := range rows {
+			stored[row.Key] = rateLimitBucket{Tokens: row.Tokens, UpdatedAt: row.UpdatedAt, FullAt: row.FullAt}
+		}
+		buckets := make([]rateLimitBucket, len(checks))
+		for i, check := range checks {
+			buckets[i] = stored[check.Key]
+		}
+
+		var taken []rateLimitBucket
+		taken, ok, retryAfter = takeAll(checks, buckets, now)
+		if !ok {
+			return nil
+		}
+		for i, check := range checks {
+			err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&RateLimitBucket{
+				Key:       check.Key,
+				Tokens:    taken[i].Tokens,
+				UpdatedAt: taken[i].UpdatedAt,
+				FullAt:    taken[i].FullAt,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return ok, retryAfter, err
+}
+
+func (rl *gormRateLimiter) sweep(now time.Time) {
+	rl.mu.Lock()
+	due := now.Sub(rl.lastSweep) > rateLimitSweepInterval
+	if due {
+		rl.lastSweep = now
+	}
+	rl.mu.Unlock()
+
+	if due {
+		if err := rl.db.Where("full_at < ?", now).Delete(&RateLimitBucket{}).Error; err != nil {
+			fmt.Println("Error sweeping rate limit buckets:", err)
+		}
+	}
+}
+
+// newRateLimiter returns the limiter for backend. The database backend
+// shares the store's connection and needs a SQL store.
+func newRateLimiter(backend string, s Store) (RateLimiter, error) {
+	switch backend {
+	case "memory":
+		return newMemoryRateLimiter(), nil
+	case "database":
+		gs, ok := s.(*gormStore)
+		if !ok {
+			return nil, errors.New("the database rate limit backend needs a SQL database")
+		}
+		return &gormRateLimiter{db: gs.db}, nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit backend %q", backend)
+	}
+}
+
+// Throttle limits a route group both per signed-in user and per client IP,
+// answering 429 with Retry-After once either bucket is empty. A request is
+// only charged when both buckets allow it. It must follow RequireAuth.
+// Limits are read from the config on every request, and a zero limit is not
+// enforced.
+func Throttle(group string, userLimit, ipLimit *RateLimit) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var checks []rateLimitCheck
+		if userLimit.Requests > 0 {
+			checks = append(checks, rateLimitCheck{fmt.Sprintf("%s:user:%d", group, currentUser(c).ID), *userLimit})
+		}
+		if ipLimit.Requests > 0 {
+			checks = append(checks, rateLimitCheck{fmt.Sprintf("%s:ip:%s", group, c.ClientIP()), *ipLimit})
+		}
+		if len(checks) == 0 {
+			c.Next()
+			return
+		}
+
+		ok, retryAfter, err := rateLimiter.Take(checks, time.Now())
+		if err != nil {
+			fmt.Println("Error checking rate limit:", err)
+		} else if !ok {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			c.AbortWithStatusJSON(429, gin.H{"error": "Too many requests"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRateLimitBucketTake(t *testing.T) {
+	limit := RateLimit{Requests: 3, Per: 3 * time.Second}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var bucket rateLimitBucket
+	take := func(at time.Duration, wantOK bool, wantRetryAfter time.Duration) {
+		t.Helper()
+		var ok bool
+		var retryAfter time.Duration
+		bucket, ok, retryAfter = bucket.take(limit, start.Add(at))
+		if ok != wantOK || retryAfter != wantRetryAfter {
+			t.Fatalf("take at %v = %v, %v, want %v, %v", at, ok, retryAfter, wantOK, wantRetryAfter)
+		}
+	}
+
+	// A new bucket starts full and allows a burst of Requests.
+	take(0, true, 0)
+	take(0, true, 0)
+	take(0, true, 0)
+	take(0, false, time.Second)
+
+	// Tokens refill evenly, one per Per/Requests.
+	take(500*time.Millisecond, false, 500*time.Millisecond)
+	take(time.Second, true, 0)
+	take(time.Second, false, time.Second)
+	take(2500*time.Millisecond, true, 0)
+	if bucket.Tokens != 0.5 {
+		t.Errorf("tokens after a partial refill = %v, want 0.5", bucket.Tokens)
+	}
+
+	// However long the bucket sits idle, it never holds more than Requests.
+	idle := time.Hour
+	take(idle, true, 0)
+	if want := start.Add(idle + time.Second); !bucket.FullAt.Equal(want) {
+		t.Errorf("FullAt = %v, want %v", bucket.FullAt, want)
+	}
+	take(idle, true, 0)
+	take(idle, true, 0)
+	take(idle, false, time.Second)
+}
+
+func testRateLimiters(t *testing.T) map[string]RateLimiter {
+	t.Helper()
+
+	s := openTestGormStore(t, DatabaseConfig{Driver: "sqlite", Name: filepath.Join(t.TempDir(), "test.db")})
+	database, err := newRateLimiter("database", s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]RateLimiter{
+		"memory":   newMemoryRateLimiter(),
+		"database": database,
+	}
+}
+
+func TestRateLimiterTake(t *testing.T) {
+	for name, rl := range testRateLimiters(t) {
+		t.Run(name, func(t *testing.T) {
+			now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			user := func(id int) rateLimitCheck {
+				return rateLimitCheck{fmt.Sprint("comments:user:", id), RateLimit{Requests: 1, Per: 10 * time.Second}}
+			}
+			ip := rateLimitCheck{"comments:ip:192.0.2.1", RateLimit{Requests: 2, Per: time.Minute}}
+
+			take := func(wantOK bool, wantRetryAfter time.Duration, checks ...rateLimitCheck) {
+				t.Helper()
+				ok, retryAfter, err := rl.Take(checks, now)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ok != wantOK || retryAfter != wantRetryAfter {
+					t.Fatalf("Take(%v) = %v, %v, want %v, %v", checks, ok, retryAfter, wantOK, wantRetryAfter)
+				}
+			}
+
+			take(true, 0, user(1), ip)
+			take(true, 0, user(2), ip)
+
+			// The IP is out of tokens, so user 3 is refused and keeps its
+			// own token for later.
+			take(false, 30*time.Second, user(3), ip)
+			take(true, 0, user(3))
+
+			// With both buckets empty the caller waits for the slower one.
+			take(false, 30*time.Second, user(1), ip)
+			now = now.Add(30 * time.Second)
+			take(true, 0, user(1), ip)
+		})
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	router := newTestRouter(t)
+	config.CommentRateLimit = RateLimit{Requests: 1, Per: time.Minute}
+	config.CommentIPRateLimit = RateLimit{}
+	newTestUser(t, 1, "owner")
+	_, author := newTestUser(t, 2, "author")
+	_, other := newTestUser(t, 3, "other")
+
+	wantStatus(t, doRequest(t, router, "POST", "/api/user/owner/comments", author, gin.H{"content": "hello"}), 200)
+
+	w := doRequest(t, router, "PATCH", "/api/user/owner/comments", author, gin.H{"content": "edited"})
+	wantStatus(t, w, 429)
+	if got := w.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After = %q, want 60", got)
+	}
+	if comments := listComments(t, router, "owner", ""); comments[0].Content != "hello" {
+		t.Errorf("a throttled edit went through: %+v", comments[0])
+	}
+
+	// The limit is per user, and the IP limit is off.
+	wantStatus(t, doRequest(t, router, "POST", "/api/user/owner/comments", other, gin.H{"content": "hi"}), 200)
+
+	config.CommentIPRateLimit = RateLimit{Requests: 2, Per: time.Hour}
+	config.CommentRateLimit = RateLimit{Requests: 10, Per: time.Minute}
+	_, third := newTestUser(t, 4, "third")
+	wantStatus(t, doRequest(t, router, "POST", "/api/user/owner/comments", third, gin.H{"content": "first"}), 200)
+	wantStatus(t, doRequest(t, router, "PATCH", "/api/user/owner/comments", third, gin.H{"content": "second"}), 200)
+	w = doRequest(t, router, "PATCH", "/api/user/owner/comments", third, gin.H{"content": "third"})
+	wantStatus(t, w, 429)
+	if got := w.Header().Get("Retry-After"); got != "1800" {
+		t.Errorf("Retry-After from the IP limit = %q, want 1800", got)
+	}
+}
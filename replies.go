@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// checkReply validates a reply by author to parentID on receiver's board,
+// returning a message fit for the client when it is not allowed.
+func checkReply(receiver, author GitHubUser, parentID uint) error {
+	if config.ReplyMaxDepth < 1 {
+		return errors.New("Replies are disabled")
+	}
+	if author.ID != receiver.ID && !config.AllowVisitorReplies {
+		return errors.New("Only the profile owner can reply to comments")
+	}
+
+	parent, err := store.FindComment(parentID)
+	if err != nil || parent.ReceiverID != receiver.ID {
+		return errors.New("Parent comment not found")
+	}
+	if parent.Pending {
+		return errors.New("Parent comment is awaiting approval")
+	}
+
+	depth := 1
+	for parent.ParentID != 0 {
+		if depth >= config.ReplyMaxDepth {
+			return fmt.Errorf("Replies can be nested at most %d deep", config.ReplyMaxDepth)
+		}
+		if parent, err = store.FindComment(parent.ParentID); err != nil {
+			return errors.New("Parent comment not found")
+		}
+		depth++
+	}
+	return nil
+}
+
+// parentIDQuery reads the optional ?parent_id= that selects one of the
+// caller's replies instead of their top-level comment.
+func parentIDQuery(c *gin.Context) (uint, bool) {
+	raw := c.Query("parent_id")
+	if raw == "" {
+		return 0, true
+	}
+	parentID, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid parent ID"})
+		return 0, false
+	}
+	return uint(parentID), true
+}
+
+// nestCommentResponses attaches every comment to its parent's Replies and
+// returns the top-level comments. Replies keep the order they are given in.
+func nestCommentResponses(comments []CommentResponse) []CommentResponse {
+	byParent := make(map[uint][]CommentResponse)
+	for _, comment := range comments {
+		byParent[comment.ParentID] = append(byParent[comment.ParentID], comment)
+	}
+
+	var attach func(parentID uint) []CommentResponse
+	attach = func(parentID uint) []CommentResponse {
+		children := make([]CommentResponse, 0, len(byParent[parentID]))
+		for _, child := range byParent[parentID] {
+			child.Replies = attach(child.ID)
+			children = append(children, child)
+		}
+		return children
+	}
+	return attach(0)
+}
+
+// flattenSVGComments orders a board for rendering: top-level comments sorted
+// by less, each followed depth-first by its replies with Depth set.
+func flattenSVGComments(comments []SvgCommentModel, less func(a, b SvgCommentModel) bool) []SvgCommentModel {
+	byParent := make(map[uint][]SvgCommentModel)
+	for _, comment := range comments {
+		byParent[comment.ParentID] = append(byParent[comment.ParentID], comment)
+	}
+
+	top := byParent[0]
+	sort.SliceStable(top, func(i, j int) bool { return less(top[i], top[j]) })
+
+	flat := make([]SvgCommentModel, 0, len(comments))
+	var walk func(siblings []SvgCommentModel, depth int)
+	walk = func(siblings []SvgCommentModel, depth int) {
+		for _, comment := range siblings {
+			comment.Depth = depth
+			flat = append(flat, comment)
+			walk(byParent[comment.ID], depth+1)
+		}
+	}
+	walk(top, 0)
+	return flat
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	reportOpen      = "open"
+	reportDismissed = "dismissed"
+)
+
+var reportReasons = []string{"spam", "harassment", "hate", "impersonation", "other"}
+
+// reportListLimit caps how many open reports the operator endpoint returns,
+// oldest first.
+const reportListLimit = 100
+
+// Report is a viewer flagging a comment for the operators. Each user can
+// report a comment once. Open reports count towards hiding the comment from
+// the SVG; resolving a report deletes the comment along with its reports.
+type Report struct {
+	ID         uint `gorm:"primary_key"`
+	CommentID  uint
+	ReporterID uint
+	Reason     string
+	Status     string
+	CreatedAt  time.Time
+}
+
+type ReportResponse struct {
+	ID        uint      `json:"id"`
+	CommentID uint      `json:"comment_id"`
+	Board     string    `json:"board"`
+	Author    string    `json:"author"`
+	Content   string    `json:"content"`
+	Reports   int       `json:"reports"`
+	Reason    string    `json:"reason"`
+	Reporter  string    `json:"reporter"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// reportHidden reports whether a comment has collected enough open reports
+// to be left out of the SVG until an operator looks at it.
+func reportHidden(reportCount int) bool {
+	return config.ReportHideThreshold > 0 && reportCount >= config.ReportHideThreshold
+}
+
+// visibleToViewers reports whether comment is shown to viewers other than
+// the board owner: hidden comments and their replies are not, and neither
+// are comments awaiting approval, which only their author also sees.
+func visibleToViewers(comment Comment) bool {
+	for {
+		if comment.Hidden || comment.Pending {
+			return false
+		}
+		if comment.ParentID == 0 {
+			return true
+		}
+		parent, err := store.FindComment(comment.ParentID)
+		if err != nil {
+			return false
+		}
+		comment = parent
+	}
+}
+
+func reportComment(c *gin.Context) {
+	commentID, err := strconv.ParseUint(c.Param("commentID"), 10, 64)
+	if err != nil || commentID == 0 {
+		c.JSON(400, gin.H{"error": "Invalid Comment ID"})
+		return
+	}
+
+	comment, err := store.FindComment(uint(commentID))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Comment not found"})
+		return
+	}
+
+	reporter := currentUser(c)
+
+	if reporter.ID != comment.ReceiverID && !visibleToViewers(comment) {
+		c.JSON(404, gin.H{"error": "Comment not found"})
+		return
+	}
+
+	if comment.AuthorID == reporter.ID {
+		c.JSON(400, gin.H{"error": "You can't report your own comment"})
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	valid := false
+	for _, reason := range reportReasons {
+		if req.Reason == reason {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		c.JSON(400, gin.H{"error": "Reason must be one of " + strings.Join(reportReasons, ", ")})
+		return
+	}
+
+	report := Report{
+		CommentID:  comment.ID,
+		ReporterID: reporter.ID,
+		Reason:     req.Reason,
+	}
+	reportCount, err := store.CreateReport(&report)
+	if err != nil {
+		if errors.Is(err, ErrReportExists) {
+			c.JSON(400, gin.H{"error": err.Error()})
+		} else if errors.Is(err, ErrNotFound) {
+			c.JSON(404, gin.H{"error": "Comment not found"})
+		} else {
+			c.JSON(500, gin.H{"error": "Failed to report comment"})
+		}
+		return
+	}
+
+	if reportHidden(reportCount) {
+		touchBoard(comment.ReceiverID)
+	}
+
+	c.JSON(201, gin.H{"message": "Comment reported"})
+}
+
+func listReports(c *gin.Context) {
+	reports, err := store.ListOpenReports(reportListLimit)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to get reports"})
+		return
+	}
+
+	logins := make(map[uint]string)
+	login := func(id uint) string {
+		if name, ok := logins[id]; ok {
+			return name
+		}
+		if user, err := store.FindUser(id); err == nil {
+			logins[id] = user.GitHubLogin
+		}
+		return logins[id]
+	}
+
+	responses := make([]ReportResponse, 0, len(reports))
+	for _, report := range reports {
+		comment, err := store.FindComment(report.CommentID)
+		if err != nil {
+			continue
+		}
+		responses = append(responses, ReportResponse{
+			ID:        report.ID,
+			CommentID: comment.ID,
+			Board:     login(comment.ReceiverID),
+			Author:    login(comment.AuthorID),
+			Content:   comment.Content,
+			Reports:   comment.ReportCount,
+			Reason:    report.Reason,
+			Reporter:  login(report.ReporterID),
+			CreatedAt: report.CreatedAt,
+		})
+	}
+	c.JSON(200, responses)
+}
+
+// openReport resolves :reportID to a report that is still open, along with
+// the reported comment. ok is false once an error response has been written.
+func openReport(c *gin.Context) (Report, Comment, bool) {
+	reportID, err := strconv.ParseUint(c.Param("reportID"), 10, 64)
+	if err != nil || reportID == 0 {
+		c.JSON(400, gin.H{"error": "Invalid report ID"})
+		return Report{}, Comment{}, false
+	}
+
+	report, err := store.FindReport(uint(reportID))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Report not found"})
+		return Report{}, Comment{}, false
+	}
+	if report.Status != reportOpen {
+		c.JSON(400, gin.H{"error": "Report is already closed"})
+		return Report{}, Comment{}, false
+	}
+
+	comment, err := store.FindComment(report.CommentID)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Comment not found"})
+		return Report{}, Comment{}, false
+	}
+	return report, comment, true
+}
+
+func resolveReport(c *gin.Context) {
+	_, comment, ok := openReport(c)
+	if !ok {
+		return
+	}
+
+	if err := store.DeleteComment(comment.ID); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to delete comment"})
+		return
+	}
+
+	recordModeration(c, comment, moderationDelete)
+	touchBoard(comment.ReceiverID)
+
+	c.JSON(200, gin.H{"message": "Report resolved, comment deleted"})
+}
+
+func dismissReport(c *gin.Context) {
+	report, comment, ok := openReport(c)
+	if !ok {
+		return
+	}
+
+	if err := store.DismissReport(report.ID); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to dismiss report"})
+		return
+	}
+
+	if reportHidden(comment.ReportCount) {
+		touchBoard(comment.ReceiverID)
+	}
+
+	c.JSON(200, gin.H{"message": "Report dismissed"})
+}
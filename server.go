@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// connectStore opens the configured store, retrying with exponential backoff
+// so the service can start alongside a database that is still booting.
+func connectStore(cfg DatabaseConfig) (Store, error) {
+	backoff := time.Duration(cfg.ConnectBackoff)
+
+	var err error
+	for attempt := 1; attempt <= cfg.ConnectAttempts; attempt++ {
+		var s Store
+		if s, err = openStore(cfg); err == nil {
+			return s, nil
+		}
+		if attempt == cfg.ConnectAttempts {
+			break
+		}
+		fmt.Printf("Error connecting to database (attempt %d/%d): %v; retrying in %s\n", attempt, cfg.ConnectAttempts, err, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	return nil, fmt.Errorf("connecting to database: %w", err)
+}
+
+// serve runs the HTTP server until SIGINT or SIGTERM, then stops accepting
+// connections and waits up to ShutdownTimeout for in-flight requests.
+func serve(handler http.Handler) error {
+	srv := &http.Server{
+		Addr:    ":" + config.Port,
+		Handler: handler,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	errCh := make(chan error, 1)
+	go func() {
+		fmt.Println("Listening on", srv.Addr)
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+	stop()
+
+	fmt.Println("Shutting down, draining requests for up to", time.Duration(config.ShutdownTimeout))
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(config.ShutdownTimeout))
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("shutting down server: %w", err)
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
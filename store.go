@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrNotFound         = errors.New("record not found")
+	ErrCommentExists    = errors.New("user already has a comment")
+	ErrReactionExists   = errors.New("user already reacted to the comment")
+	ErrReactionConflict = errors.New("user already reacted the other way to the comment")
+	ErrBlockExists      = errors.New("user is already blocked")
+	ErrReportExists     = errors.New("comment already reported")
+)
+
+// CommentView is a comment joined with its author and reaction counts, plus
+// whether the viewer passed to ListCommentViews has liked or disliked it.
+type CommentView struct {
+	ID             uint
+	ParentID       uint
+	AuthorID       uint
+	Author         string
+	Content        string
+	IsOwnerLiked   bool
+	Hidden         bool
+	Pending        bool
+	Likes          int
+	Dislikes       int
+	IsLiked        bool
+	IsDisliked     bool
+	PinnedPosition int
+	ReportCount    int
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// Edited reports whether the author changed the comment after posting it.
+// Only content edits move UpdatedAt; every other write to a comment uses
+// UpdateColumn so that it keeps this meaning.
+func (v CommentView) Edited() bool {
+	return v.UpdatedAt.After(v.CreatedAt)
+}
+
+// Store is the persistence layer used by the HTTP handlers.
+type Store interface {
+	ListUsers() ([]GitHubUser, error)
+	FindUser(id uint) (GitHubUser, error)
+	FindUserByLogin(login string) (GitHubUser, error)
+	FindUserByGitHubID(githubID float64) (GitHubUser, error)
+	SaveUser(githubID float64, login string) (GitHubUser, error)
+	TouchBoard(receiverID uint) (GitHubUser, error)
+	SetRequireApproval(userID uint, required bool) error
+
+	CreateComment(comment *Comment) error
+	FindComment(id uint) (Comment, error)
+	FindCommentByAuthor(receiverID, authorID, parentID uint) (Comment, error)
+	ListCommentViews(receiverID, viewerID uint) ([]CommentView, error)
+	UpdateCommentContent(id uint, content string, pending bool) error
+	DeleteComment(id uint) error
+	SetOwnerLiked(commentID uint, liked bool) error
+	SetCommentHidden(commentID uint, hidden bool) error
+	SetCommentPending(commentID uint, pending bool) error
+	PinnedCommentIDs(receiverID uint) ([]uint, error)
+	SetPinnedComments(receiverID uint, commentIDs []uint) error
+
+	HasLiked(commentID, userID uint) (bool, error)
+	HasDisliked(commentID, userID uint) (bool, error)
+	AddLike(commentID, userID uint) error
+	RemoveLike(commentID, userID uint) error
+	AddDislike(commentID, userID uint) error
+	RemoveDislike(commentID, userID uint) error
+	RecountReactions() (int64, error)
+
+	CreateAPIToken(token *APIToken) error
+	FindAPIToken(tokenHash string) (APIToken, error)
+	ListAPITokens(userID uint) ([]APIToken, error)
+	TouchAPIToken(id uint, usedAt time.Time) error
+	DeleteAPIToken(userID, id uint) error
+
+	CreateReport(report *Report) (int, error)
+	FindReport(id uint) (Report, error)
+	ListOpenReports(limit int) ([]Report, error)
+	DismissReport(id uint) error
+
+	CreateFilterRule(rule *FilterRule) error
+	ListFilterRules(receiverID uint) ([]FilterRule, error)
+	DeleteFilterRule(receiverID, id uint) error
+
+	CreateBlock(block *Block) error
+	IsBlocked(receiverID, userID uint) (bool, error)
+	ListBlocks(receiverID uint) ([]Block, error)
+	DeleteBlock(receiverID, blockedID uint) error
+
+	CreateModerationAction(action *ModerationAction) error
+	ListModerationActions(receiverID uint, limit int) ([]ModerationAction, error)
+
+	CreateUserSession(session *UserSession) error
+	FindUserSession(tokenHash string) (UserSession, error)
+	ListUserSessions(userID uint, now time.Time) ([]UserSession, error)
+	TouchUserSession(id uint, seenAt time.Time) error
+	DeleteUserSession(userID, id uint) error
+	DeleteUserSessions(userID uint) (int64, error)
+	DeleteExpiredUserSessions(now time.Time) (int64, error)
+
+	Migrate() error
+	Close() error
+}
+
+func openStore(cfg DatabaseConfig) (Store, error) {
+	if cfg.Driver == "memory" {
+		return newMemoryStore(), nil
+	}
+	return openGormStore(cfg)
+}
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type gormStore struct {
+	db *gorm.DB
+}
+
+func openGormStore(cfg DatabaseConfig) (Store, error) {
+	dialector, err := gormDialector(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// TranslateError turns unique constraint violations from every driver
+	// into gorm.ErrDuplicatedKey, which the store maps to its own errors.
+	db, err := gorm.Open(dialector, &gorm.Config{TranslateError: true})
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Driver == "sqlite" {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil, err
+		}
+		sqlDB.SetMaxOpenConns(1)
+	}
+
+	return &gormStore{db: db}, nil
+}
+
+func gormDialector(cfg DatabaseConfig) (gorm.Dialector, error) {
+	switch cfg.Driver {
+	case "mysql":
+		dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local&clientFoundRows=true", cfg.User, cfg.Password, cfg.Host, cfg.Port, cfg.Name)
+		return mysql.Open(dsn), nil
+	case "postgres":
+		dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s", cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.Name, cfg.SSLMode)
+		return postgres.Open(dsn), nil
+	case "sqlite":
+		name := cfg.Name
+		if name == "" {
+			name = "comments.db"
+		}
+		return sqlite.Open(name + "?_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)"), nil
+	default:
+		return nil, fmt.Errorf("unsupported database driver %q", cfg.Driver)
+	}
+}
+
+func notFound(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// updated maps an update that matched no rows to ErrNotFound. MySQL is
+// opened with clientFoundRows so that rows already holding the new value
+// still count as matched.
+func updated(result *gorm.DB) error {
+	if result.Error == nil && result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return result.Error
+}
+
+// duplicate maps a unique constraint violation to exists. The constraints
+// are what keep concurrent writers, possibly on other instances, from
+// inserting the same row twice.
+func duplicate(err, exists error) error {
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return exists
+	}
+	return err
+}
+
+func (s *gormStore) ListUsers() ([]GitHubUser, error) {
+	var users []GitHubUser
+	err := s.db.Order("id").Find(&users).Error
+	return users, err
+}
+
+func (s *gormStore) FindUser(id uint) (GitHubUser, error) {
+	var user GitHubUser
+	err := s.db.First(&user, id).Error
+	return user, notFound(err)
+}
+
+func (s *gormStore) FindUserByLogin(login string) (GitHubUser, error) {
+	var user GitHubUser
+	err := s.db.Where("LOWER(git_hub_login) = LOWER(?)", login).First(&user).Error
+	return user, notFound(err)
+}
+
+func (s *gormStore) FindUserByGitHubID(githubID float64) (GitHubUser, error) {
+	var user GitHubUser
+	err := s.db.Where("git_hub_id = ?", githubID).First(&user).Error
+	return user, notFound(err)
+}
+
+func (s *gormStore) SaveUser(githubID float64, login string) (GitHubUser, error) {
+	var user GitHubUser
+	if err := s.db.Where("git_hub_id = ?", githubID).First(&user).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return user, err
+		}
+		user = GitHubUser{
+			GitHubID:    githubID,
+			GitHubLogin: login,
+		}
+		return user, s.db.Create(&user).Error
+	}
+
+	if user.GitHubLogin != login {
+		if err := s.db.Model(&user).Update("git_hub_login", login).Error; err != nil {
+			return user, err
+		}
+	}
+	return user, nil
+}
+
+func (s *gormStore) TouchBoard(receiverID uint) (GitHubUser, error) {
+	err := updated(s.db.Model(&GitHubUser{}).Where("id = ?", receiverID).UpdateColumn("board_revision", gorm.Expr("board_revision + 1")))
+	if err != nil {
+		return GitHubUser{}, err
+	}
+	return s.FindUser(receiverID)
+}
+
+func (s *gormStore) SetRequireApproval(userID uint, required bool) error {
+	return updated(s.db.Model(&GitHubUser{}).Where("id = ?", userID).UpdateColumn("require_approval", required))
+}
+
+func (s *gormStore) CreateComment(comment *Comment) error {
+	return duplicate(s.db.Create(comment).Error, ErrCommentExists)
+}
+
+func (s *gormStore) FindComment(id uint) (Comment, error) {
+	var comment Comment
+	err := s.db.First(&comment, id).Error
+	return comment, notFound(err)
+}
+
+func (s *gormStore) FindCommentByAuthor(receiverID, authorID, parentID uint) (Comment, error) {
+	var comment Comment
+	err := s.db.Where("receiver_id = ? AND author_id = ? AND parent_id = ?", receiverID, authorID, parentID).First(&comment).Error
+	return comment, notFound(err)
+}
+
+// ListCommentViews loads a receiver's whole board in a single query.
+func (s *gormStore) ListCommentViews(receiverID, viewerID uint) ([]CommentView, error) {
+	var views []CommentView
+	err := s.db.Table("comments").
+		Select(`comments.id, comments.parent_id, comments.author_id, git_hub_users.git_hub_login AS author, comments.content, comments.is_owner_liked, comments.hidden, comments.pending,
+			comments.like_count AS likes, comments.dislike_count AS dislikes, comments.pinned_position, comments.report_count, comments.created_at, comments.updated_at,
+			viewer_likes.id IS NOT NULL AS is_liked, viewer_dislikes.id IS NOT NULL AS is_disliked`).
+		Joins("JOIN git_hub_users ON git_hub_users.id = comments.author_id").
+		Joins("LEFT JOIN likeds AS viewer_likes ON viewer_likes.comment_id = comments.id AND viewer_likes.user_id = ?", viewerID).
+		Joins("LEFT JOIN dislikeds AS viewer_dislikes ON viewer_dislikes.comment_id = comments.id AND viewer_dislikes.user_id = ?", viewerID).
+		Where("comments.receiver_id = ?", receiverID).
+		Order("comments.id").
+		Scan(&views).Error
+	return views, err
+}
+
+// UpdateCommentContent replaces a comment's text and, when pending is set,
+// puts it back into the approval queue in the same statement.
+func (s *gormStore) UpdateCommentContent(id uint, content string, pending bool) error {
+	columns := map[string]any{"content": content}
+	if pending {
+		columns["pending"] = true
+	}
+	return updated(s.db.Model(&Comment{}).Where("id = ?", id).Updates(columns))
+}
+
+// DeleteComment deletes a comment together with all of its replies and
+// their reactions.
+func (s *gormStore) DeleteComment(id uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		ids := []uint{id}
+		for parents := ids; len(parents) > 0; {
+			var children []uint
+			if err := tx.Model(&Comment{}).Where("parent_id IN ?", parents).Pluck("id", &children).Error; err != nil {
+				return err
+			}
+			ids = append(ids, children...)
+			parents = children
+		}
+
+		if err := tx.Where("comment_id IN ?", ids).Delete(&Liked{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("comment_id IN ?", ids).Delete(&Disliked{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("comment_id IN ?", ids).Delete(&Report{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id IN ?", ids).Delete(&Comment{}).Error
+	})
+}
+
+func (s *gormStore) SetOwnerLiked(commentID uint, liked bool) error {
+	return updated(s.db.Model(&Comment{}).Where("id = ?", commentID).UpdateColumn("is_owner_liked", liked))
+}
+
+func (s *gormStore) SetCommentHidden(commentID uint, hidden bool) error {
+	return updated(s.db.Model(&Comment{}).Where("id = ?", commentID).UpdateColumn("hidden", hidden))
+}
+
+func (s *gormStore) SetCommentPending(commentID uint, pending bool) error {
+	return updated(s.db.Model(&Comment{}).Where("id = ?", commentID).UpdateColumn("pending", pending))
+}
+
+func (s *gormStore) PinnedCommentIDs(receiverID uint) ([]uint, error) {
+	var ids []uint
+	err := s.db.Model(&Comment{}).Where("receiver_id = ? AND pinned_position > 0", receiverID).Order("pinned_position").Pluck("id", &ids).Error
+	return ids, err
+}
+
+// SetPinnedComments makes commentIDs the receiver's pins, in that order,
+// unpinning every other comment on the board.
+func (s *gormStore) SetPinnedComments(receiverID uint, commentIDs []uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Comment{}).Where("receiver_id = ? AND pinned_position > 0", receiverID).UpdateColumn("pinned_position", 0).Error
+		if err != nil {
+			return err
+		}
+		for i, id := range commentIDs {
+			err := tx.Model(&Comment{}).Where("id = ? AND receiver_id = ?", id, receiverID).UpdateColumn("pinned_position", i+1).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *gormStore) HasLiked(commentID, userID uint) (bool, error) {
+	var count int64
+	err := s.db.Model(&Liked{}).Where("comment_id = ? AND user_id = ?", commentID, userID).Count(&count).Error
+	return count > 0, err
+}
+
+func (s *gormStore) HasDisliked(commentID, userID uint) (bool, error) {
+	var count int64
+	err := s.db.Model(&Disliked{}).Where("comment_id = ? AND user_id = ?", commentID, userID).Count(&count).Error
+	return count > 0, err
+}
+
+func (s *gormStore) AddLike(commentID, userID uint) error {
+	return s.addReaction(&Liked{CommentID: commentID, UserID: userID}, &Disliked{}, commentID, userID, "like_count")
+}
+
+func (s *gormStore) RemoveLike(commentID, userID uint) error {
+	return s.removeReaction(&Liked{}, commentID, userID, "like_count")
+}
+
+func (s *gormStore) AddDislike(commentID, userID uint) error {
+	return s.addReaction(&Disliked{CommentID: commentID, UserID: userID}, &Liked{}, commentID, userID, "dislike_count")
+}
+
+func (s *gormStore) RemoveDislike(commentID, userID uint) error {
+	return s.removeReaction(&Disliked{}, commentID, userID, "dislike_count")
+}
+
+// addReaction inserts row unless the user already has the opposite
+// reaction on the comment. Every reaction locks the comment row first, so a
+// like and a dislike from the same user can't both pass the check.
+func (s *gormStore) addReaction(row, opposite any, commentID, userID uint, counter string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var comment Comment
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&comment, commentID).Error; err != nil {
+			return notFound(err)
+		}
+
+		var count int64
+		if err := tx.Model(opposite).Where("comment_id = ? AND user_id = ?", commentID, userID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrReactionConflict
+		}
+
+		if err := tx.Create(row).Error; err != nil {
+			return duplicate(err, ErrReactionExists)
+		}
+		return updated(tx.Model(&Comment{}).Where("id = ?", commentID).UpdateColumn(counter, gorm.Expr(counter+" + 1")))
+	})
+}
+
+func (s *gormStore) removeReaction(model any, commentID, userID uint, counter string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("comment_id = ? AND user_id = ?", commentID, userID).Delete(model)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		return tx.Model(&Comment{}).Where("id = ?", commentID).UpdateColumn(counter, gorm.Expr(counter+" - ?", result.RowsAffected)).Error
+	})
+}
+
+func (s *gormStore) RecountReactions() (int64, error) {
+	return recountReactions(s.db)
+}
+
+// recountReactions rewrites the denormalized like/dislike counters of every
+// comment whose stored value has drifted from the reaction rows, returning
+// the number of comments repaired.
+func recountReactions(db *gorm.DB) (int64, error) {
+	const (
+		likes    = "(SELECT COUNT(*) FROM likeds WHERE likeds.comment_id = comments.id)"
+		dislikes = "(SELECT COUNT(*) FROM dislikeds WHERE dislikeds.comment_id = comments.id)"
+	)
+	result := db.Exec("UPDATE comments SET like_count = " + likes + ", dislike_count = " + dislikes +
+		" WHERE like_count <> " + likes + " OR dislike_count <> " + dislikes)
+	return result.RowsAffected, result.Error
+}
+
+func (s *gormStore) CreateAPIToken(token *APIToken) error {
+	return s.db.Create(token).Error
+}
+
+func (s *gormStore) FindAPIToken(tokenHash string) (APIToken, error) {
+	var token APIToken
+	err := s.db.Where("token_hash = ?", tokenHash).First(&token).Error
+	return token, notFound(err)
+}
+
+func (s *gormStore) ListAPITokens(userID uint) ([]APIToken, error) {
+	var tokens []APIToken
+	err := s.db.Where("user_id = ?", userID).Order("id").Find(&tokens).Error
+	return tokens, err
+}
+
+func (s *gormStore) TouchAPIToken(id uint, usedAt time.Time) error {
+	return updated(s.db.Model(&APIToken{}).Where("id = ?", id).UpdateColumn("last_used_at", usedAt))
+}
+
+func (s *gormStore) DeleteAPIToken(userID, id uint) error {
+	result := s.db.Where("id = ? AND user_id = ?", id, userID).Delete(&APIToken{})
+	if result.Error == nil && result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return result.Error
+}
+
+// CreateReport returns the comment's report count including the new report,
+// read back in the same transaction so concurrent reports each see their own.
+func (s *gormStore) CreateReport(report *Report) (int, error) {
+	report.Status = reportOpen
+	var count int
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(report).Error; err != nil {
+			return duplicate(err, ErrReportExists)
+		}
+		if err := updated(tx.Model(&Comment{}).Where("id = ?", report.CommentID).UpdateColumn("report_count", gorm.Expr("report_count + 1"))); err != nil {
+			return err
+		}
+		return tx.Model(&Comment{}).Where("id = ?", report.CommentID).Select("report_count").Scan(&count).Error
+	})
+	return count, err
+}
+
+func (s *gormStore) FindReport(id uint) (Report, error) {
+	var report Report
+	err := s.db.First(&report, id).Error
+	return report, notFound(err)
+}
+
+func (s *gormStore) ListOpenReports(limit int) ([]Report, error) {
+	var reports []Report
+	err := s.db.Where("status = ?", reportOpen).Order("id").Limit(limit).Find(&reports).Error
+	return reports, err
+}
+
+func (s *gormStore) DismissReport(id uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var report Report
+		if err := tx.Where("id = ? AND status = ?", id, reportOpen).First(&report).Error; err != nil {
+			return notFound(err)
+		}
+
+		if err := tx.Model(&report).UpdateColumn("status", reportDismissed).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Comment{}).Where("id = ?", report.CommentID).UpdateColumn("report_count", gorm.Expr("report_count - 1")).Error
+	})
+}
+
+func (s *gormStore) CreateFilterRule(rule *FilterRule) error {
+	return s.db.Create(rule).Error
+}
+
+func (s *gormStore) ListFilterRules(receiverID uint) ([]FilterRule, error) {
+	var rules []FilterRule
+	err := s.db.Where("receiver_id = ?", receiverID).Order("id").Find(&rules).Error
+	return rules, err
+}
+
+func (s *gormStore) DeleteFilterRule(receiverID, id uint) error {
+	result := s.db.Where("id = ? AND receiver_id = ?", id, receiverID).Delete(&FilterRule{})
+	if result.Error == nil && result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return result.Error
+}
+
+func (s *gormStore) CreateBlock(block *Block) error {
+	return duplicate(s.db.Create(block).Error, ErrBlockExists)
+}
+
+func (s *gormStore) IsBlocked(receiverID, userID uint) (bool, error) {
+	var count int64
+	err := s.db.Model(&Block{}).Where("receiver_id = ? AND blocked_id = ?", receiverID, userID).Count(&count).Error
+	return count > 0, err
+}
+
+func (s *gormStore) ListBlocks(receiverID uint) ([]Block, error) {
+	var blocks []Block
+	err := s.db.Where("receiver_id = ?", receiverID).Order("id").Find(&blocks).Error
+	return blocks, err
+}
+
+func (s *gormStore) DeleteBlock(receiverID, blockedID uint) error {
+	result := s.db.Where("receiver_id = ? AND blocked_id = ?", receiverID, blockedID).Delete(&Block{})
+	if result.Error == nil && result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return result.Error
+}
+
+func (s *gormStore) CreateModerationAction(action *ModerationAction) error {
+	return s.db.Create(action).Error
+}
+
+func (s *gormStore) ListModerationActions(receiverID uint, limit int) ([]ModerationAction, error) {
+	var actions []ModerationAction
+	err := s.db.Where("receiver_id = ?", receiverID).Order("id DESC").Limit(limit).Find(&actions).Error
+	return actions, err
+}
+
+func (s *gormStore) CreateUserSession(session *UserSession) error {
+	return s.db.Create(session).Error
+}
+
+func (s *gormStore) FindUserSession(tokenHash string) (UserSession, error) {
+	var session UserSession
+	err := s.db.Where("token_hash = ?", tokenHash).First(&session).Error
+	return session, notFound(err)
+}
+
+func (s *gormStore) ListUserSessions(userID uint, now time.Time) ([]UserSession, error) {
+	var sessions []UserSession
+	err := s.db.Where("user_id = ? AND expires_at > ?", userID, now).Order("last_seen_at DESC").Find(&sessions).Error
+	return sessions, err
+}
+
+func (s *gormStore) TouchUserSession(id uint, seenAt time.Time) error {
+	return updated(s.db.Model(&UserSession{}).Where("id = ?", id).UpdateColumn("last_seen_at", seenAt))
+}
+
+func (s *gormStore) DeleteUserSession(userID, id uint) error {
+	result := s.db.Where("id = ? AND user_id = ?", id, userID).Delete(&UserSession{})
+	if result.Error == nil && result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return result.Error
+}
+
+func (s *gormStore) DeleteUserSessions(userID uint) (int64, error) {
+	result := s.db.Where("user_id = ?", userID).Delete(&UserSession{})
+	return result.RowsAffected, result.Error
+}
+
+func (s *gormStore) DeleteExpiredUserSessions(now time.Time) (int64, error) {
+	result := s.db.Where("expires_at <= ?", now).Delete(&UserSession{})
+	return result.RowsAffected, result.Error
+}
+
+func (s *gormStore) Close() error {
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// countQueries counts every statement the store's connection runs from now
+// on.
+func countQueries(t testing.TB, s *gormStore) *int64 {
+	t.Helper()
+
+	var n int64
+	count := func(*gorm.DB) { atomic.AddInt64(&n, 1) }
+	callbacks := s.db.Callback()
+	for _, err := range []error{
+		callbacks.Query().After("gorm:query").Register("test:count_query", count),
+		callbacks.Row().After("gorm:row").Register("test:count_row", count),
+		callbacks.Raw().After("gorm:raw").Register("test:count_raw", count),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &n
+}
+
+// seedBoard fills a board with comments from distinct authors, each liked
+// and disliked by a few other users and some of them replied to.
+func seedBoard(t testing.TB, s *gormStore, comments int) (owner, viewer GitHubUser) {
+	t.Helper()
+
+	owner = mustSaveUser(t, s, 1, "owner")
+	viewer = mustSaveUser(t, s, 2, "viewer")
+	for i := 0; i < comments; i++ {
+		author := mustSaveUser(t, s, float64(100+i), fmt.Sprintf("author%d", i))
+		comment := mustCreateComment(t, s, Comment{ReceiverID: owner.ID, AuthorID: author.ID, Content: fmt.Sprintf("comment %d", i)})
+		if err := s.AddLike(comment.ID, viewer.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.AddDislike(comment.ID, owner.ID); err != nil {
+			t.Fatal(err)
+		}
+		if i%3 == 0 {
+			mustCreateComment(t, s, Comment{ReceiverID: owner.ID, AuthorID: owner.ID, ParentID: comment.ID, Content: "reply"})
+		}
+	}
+	return owner, viewer
+}
+
+func TestListCommentViewsQueryCount(t *testing.T) {
+	const comments = 200
+
+	s := openTestGormStore(t, DatabaseConfig{Driver: "sqlite", Name: filepath.Join(t.TempDir(), "test.db")})
+	owner, viewer := seedBoard(t, s, comments)
+
+	queries := countQueries(t, s)
+	views, err := s.ListCommentViews(owner.ID, viewer.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := comments + (comments+2)/3; len(views) != want {
+		t.Fatalf("got %d comments, want %d", len(views), want)
+	}
+	for _, view := range views {
+		if view.ParentID == 0 && (view.Likes != 1 || view.Dislikes != 1 || !view.IsLiked || view.IsDisliked) {
+			t.Fatalf("comment %d = %+v, want one like by the viewer and one dislike", view.ID, view)
+		}
+	}
+	if n := atomic.LoadInt64(queries); n != 1 {
+		t.Errorf("ListCommentViews ran %d queries for %d comments, want 1", n, len(views))
+	}
+}
+
+func BenchmarkListCommentViews(b *testing.B) {
+	s := openTestGormStore(b, DatabaseConfig{Driver: "sqlite", Name: filepath.Join(b.TempDir(), "bench.db")})
+	owner, viewer := seedBoard(b, s, 200)
+
+	queries := countQueries(b, s)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.ListCommentViews(owner.ID, viewer.ID); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(atomic.LoadInt64(queries))/float64(b.N), "queries/op")
+}
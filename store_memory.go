@@ -0,0 +1,769 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type reaction struct {
+	CommentID uint
+	UserID    uint
+}
+
+type memoryStore struct {
+	mu         sync.RWMutex
+	nextID     uint
+	users      map[uint]GitHubUser
+	comments   map[uint]Comment
+	likes      map[reaction]bool
+	dislikes   map[reaction]bool
+	tokens     map[uint]APIToken
+	sessions   map[uint]UserSession
+	reports    map[uint]Report
+	filters    map[uint]FilterRule
+	blocks     map[uint]Block
+	moderation []ModerationAction
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		users:    make(map[uint]GitHubUser),
+		comments: make(map[uint]Comment),
+		likes:    make(map[reaction]bool),
+		dislikes: make(map[reaction]bool),
+		tokens:   make(map[uint]APIToken),
+		sessions: make(map[uint]UserSession),
+		reports:  make(map[uint]Report),
+		filters:  make(map[uint]FilterRule),
+		blocks:   make(map[uint]Block),
+	}
+}
+
+func (s *memoryStore) id() uint {
+	s.nextID++
+	return s.nextID
+}
+
+func (s *memoryStore) ListUsers() ([]GitHubUser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := make([]GitHubUser, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+func (s *memoryStore) FindUser(id uint) (GitHubUser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return GitHubUser{}, ErrNotFound
+	}
+	return user, nil
+}
+
+func (s *memoryStore) FindUserByLogin(login string) (GitHubUser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, user := range s.users {
+		if strings.EqualFold(user.GitHubLogin, login) {
+			return user, nil
+		}
+	}
+	return GitHubUser{}, ErrNotFound
+}
+
+func (s *memoryStore) FindUserByGitHubID(githubID float64) (GitHubUser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, user := range s.users {
+		if user.GitHubID == githubID {
+			return user, nil
+		}
+	}
+	return GitHubUser{}, ErrNotFound
+}
+
+func (s *memoryStore) SaveUser(githubID float64, login string) (GitHubUser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, user := range s.users {
+		if user.GitHubID == githubID {
+			user.GitHubLogin = login
+			s.users[id] = user
+			return user, nil
+		}
+	}
+
+	user := GitHubUser{ID: s.id(), GitHubID: githubID, GitHubLogin: login}
+	s.users[user.ID] = user
+	return user, nil
+}
+
+func (s *memoryStore) TouchBoard(receiverID uint) (GitHubUser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[receiverID]
+	if !ok {
+		return GitHubUser{}, ErrNotFound
+	}
+	user.BoardRevision++
+	s.users[receiverID] = user
+	return user, nil
+}
+
+func (s *memoryStore) SetRequireApproval(userID uint, required bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return ErrNotFound
+	}
+	user.RequireApproval = required
+	s.users[userID] = user
+	return nil
+}
+
+func (s *memoryStore) CreateComment(comment *Comment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.comments {
+		if existing.ReceiverID == comment.ReceiverID && existing.AuthorID == comment.AuthorID && existing.ParentID == comment.ParentID {
+			return ErrCommentExists
+		}
+	}
+
+	comment.ID = s.id()
+	comment.CreatedAt = time.Now()
+	comment.UpdatedAt = comment.CreatedAt
+	s.comments[comment.ID] = *comment
+	return nil
+}
+
+func (s *memoryStore) FindComment(id uint) (Comment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	comment, ok := s.comments[id]
+	if !ok {
+		return Comment{}, ErrNotFound
+	}
+	return comment, nil
+}
+
+func (s *memoryStore) FindCommentByAuthor(receiverID, authorID, parentID uint) (Comment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, comment := range s.comments {
+		if comment.ReceiverID == receiverID && comment.AuthorID == authorID && comment.ParentID == parentID {
+			return comment, nil
+		}
+	}
+	return Comment{}, ErrNotFound
+}
+
+func (s *memoryStore) ListCommentViews(receiverID, viewerID uint) ([]CommentView, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var views []CommentView
+	for _, comment := range s.comments {
+		if comment.ReceiverID != receiverID {
+			continue
+		}
+		author, ok := s.users[comment.AuthorID]
+		if !ok {
+			continue
+		}
+		views = append(views, CommentView{
+			ID:             comment.ID,
+			ParentID:       comment.ParentID,
+			AuthorID:       comment.AuthorID,
+			Author:         author.GitHubLogin,
+			Content:        comment.Content,
+			IsOwnerLiked:   comment.IsOwnerLiked,
+			Hidden:         comment.Hidden,
+			Pending:        comment.Pending,
+			Likes:          comment.LikeCount,
+			Dislikes:       comment.DislikeCount,
+			IsLiked:        s.likes[reaction{comment.ID, viewerID}],
+			IsDisliked:     s.dislikes[reaction{comment.ID, viewerID}],
+			PinnedPosition: comment.PinnedPosition,
+			ReportCount:    comment.ReportCount,
+			CreatedAt:      comment.CreatedAt,
+			UpdatedAt:      comment.UpdatedAt,
+		})
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i].ID < views[j].ID })
+	return views, nil
+}
+
+func (s *memoryStore) UpdateCommentContent(id uint, content string, pending bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	comment, ok := s.comments[id]
+	if !ok {
+		return ErrNotFound
+	}
+	comment.Content = content
+	comment.Pending = comment.Pending || pending
+	comment.UpdatedAt = time.Now()
+	s.comments[id] = comment
+	return nil
+}
+
+func (s *memoryStore) DeleteComment(id uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deleteComment(id)
+	return nil
+}
+
+// deleteComment removes a comment, its replies and their reactions and
+// reports. The caller must hold the write lock.
+func (s *memoryStore) deleteComment(id uint) {
+	for childID, comment := range s.comments {
+		if comment.ParentID == id {
+			s.deleteComment(childID)
+		}
+	}
+	for r := range s.likes {
+		if r.CommentID == id {
+			delete(s.likes, r)
+		}
+	}
+	for r := range s.dislikes {
+		if r.CommentID == id {
+			delete(s.dislikes, r)
+		}
+	}
+	for reportID, report := range s.reports {
+		if report.CommentID == id {
+			delete(s.reports, reportID)
+		}
+	}
+	delete(s.comments, id)
+}
+
+func (s *memoryStore) SetOwnerLiked(commentID uint, liked bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	comment, ok := s.comments[commentID]
+	if !ok {
+		return ErrNotFound
+	}
+	comment.IsOwnerLiked = liked
+	s.comments[commentID] = comment
+	return nil
+}
+
+func (s *memoryStore) SetCommentHidden(commentID uint, hidden bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	comment, ok := s.comments[commentID]
+	if !ok {
+		return ErrNotFound
+	}
+	comment.Hidden = hidden
+	s.comments[commentID] = comment
+	return nil
+}
+
+func (s *memoryStore) SetCommentPending(commentID uint, pending bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	comment, ok := s.comments[commentID]
+	if !ok {
+		return ErrNotFound
+	}
+	comment.Pending = pending
+	s.comments[commentID] = comment
+	return nil
+}
+
+func (s *memoryStore) PinnedCommentIDs(receiverID uint) ([]uint, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var pinned []Comment
+	for _, comment := range s.comments {
+		if comment.ReceiverID == receiverID && comment.PinnedPosition > 0 {
+			pinned = append(pinned, comment)
+		}
+	}
+	sort.Slice(pinned, func(i, j int) bool { return pinned[i].PinnedPosition < pinned[j].PinnedPosition })
+
+	ids := make([]uint, 0, len(pinned))
+	for _, comment := range pinned {
+		ids = append(ids, comment.ID)
+	}
+	return ids, nil
+}
+
+func (s *memoryStore) SetPinnedComments(receiverID uint, commentIDs []uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	positions := make(map[uint]int, len(commentIDs))
+	for i, id := range commentIDs {
+		positions[id] = i + 1
+	}
+	for id, comment := range s.comments {
+		if comment.ReceiverID == receiverID {
+			comment.PinnedPosition = positions[id]
+			s.comments[id] = comment
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) count(set map[reaction]bool, commentID uint) int {
+	count := 0
+	for r := range set {
+		if r.CommentID == commentID {
+			count++
+		}
+	}
+	return count
+}
+
+func (s *memoryStore) HasLiked(commentID, userID uint) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.likes[reaction{commentID, userID}], nil
+}
+
+func (s *memoryStore) HasDisliked(commentID, userID uint) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.dislikes[reaction{commentID, userID}], nil
+}
+
+func (s *memoryStore) AddLike(commentID, userID uint) error {
+	return s.setReaction(reaction{commentID, userID}, false, true)
+}
+
+func (s *memoryStore) RemoveLike(commentID, userID uint) error {
+	return s.setReaction(reaction{commentID, userID}, false, false)
+}
+
+func (s *memoryStore) AddDislike(commentID, userID uint) error {
+	return s.setReaction(reaction{commentID, userID}, true, true)
+}
+
+func (s *memoryStore) RemoveDislike(commentID, userID uint) error {
+	return s.setReaction(reaction{commentID, userID}, true, false)
+}
+
+func (s *memoryStore) setReaction(r reaction, dislike, on bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	comment, ok := s.comments[r.CommentID]
+	if !ok {
+		if !on {
+			return nil
+		}
+		return ErrNotFound
+	}
+
+	set, opposite, counter := s.likes, s.dislikes, &comment.LikeCount
+	if dislike {
+		set, opposite, counter = s.dislikes, s.likes, &comment.DislikeCount
+	}
+	if on && opposite[r] {
+		return ErrReactionConflict
+	}
+	if set[r] == on {
+		if on {
+			return ErrReactionExists
+		}
+		return nil
+	}
+
+	if on {
+		set[r] = true
+		*counter++
+	} else {
+		delete(set, r)
+		*counter--
+	}
+	s.comments[r.CommentID] = comment
+	return nil
+}
+
+func (s *memoryStore) RecountReactions() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var repaired int64
+	for id, comment := range s.comments {
+		likes, dislikes := s.count(s.likes, id), s.count(s.dislikes, id)
+		if comment.LikeCount != likes || comment.DislikeCount != dislikes {
+			comment.LikeCount, comment.DislikeCount = likes, dislikes
+			s.comments[id] = comment
+			repaired++
+		}
+	}
+	return repaired, nil
+}
+
+func (s *memoryStore) CreateAPIToken(token *APIToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token.ID = s.id()
+	token.CreatedAt = time.Now()
+	s.tokens[token.ID] = *token
+	return nil
+}
+
+func (s *memoryStore) FindAPIToken(tokenHash string) (APIToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, token := range s.tokens {
+		if token.TokenHash == tokenHash {
+			return token, nil
+		}
+	}
+	return APIToken{}, ErrNotFound
+}
+
+func (s *memoryStore) ListAPITokens(userID uint) ([]APIToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var tokens []APIToken
+	for _, token := range s.tokens {
+		if token.UserID == userID {
+			tokens = append(tokens, token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ID < tokens[j].ID })
+	return tokens, nil
+}
+
+func (s *memoryStore) TouchAPIToken(id uint, usedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.tokens[id]
+	if !ok {
+		return ErrNotFound
+	}
+	token.LastUsedAt = &usedAt
+	s.tokens[id] = token
+	return nil
+}
+
+func (s *memoryStore) DeleteAPIToken(userID, id uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.tokens[id]
+	if !ok || token.UserID != userID {
+		return ErrNotFound
+	}
+	delete(s.tokens, id)
+	return nil
+}
+
+func (s *memoryStore) CreateReport(report *Report) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.reports {
+		if existing.CommentID == report.CommentID && existing.ReporterID == report.ReporterID {
+			return 0, ErrReportExists
+		}
+	}
+
+	comment, ok := s.comments[report.CommentID]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	comment.ReportCount++
+	s.comments[comment.ID] = comment
+
+	report.ID = s.id()
+	report.Status = reportOpen
+	report.CreatedAt = time.Now()
+	s.reports[report.ID] = *report
+	return comment.ReportCount, nil
+}
+
+func (s *memoryStore) FindReport(id uint) (Report, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	report, ok := s.reports[id]
+	if !ok {
+		return Report{}, ErrNotFound
+	}
+	return report, nil
+}
+
+func (s *memoryStore) ListOpenReports(limit int) ([]Report, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var reports []Report
+	for _, report := range s.reports {
+		if report.Status == reportOpen {
+			reports = append(reports, report)
+		}
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].ID < reports[j].ID })
+	if len(reports) > limit {
+		reports = reports[:limit]
+	}
+	return reports, nil
+}
+
+func (s *memoryStore) DismissReport(id uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	report, ok := s.reports[id]
+	if !ok || report.Status != reportOpen {
+		return ErrNotFound
+	}
+	report.Status = reportDismissed
+	s.reports[id] = report
+
+	if comment, ok := s.comments[report.CommentID]; ok {
+		comment.ReportCount--
+		s.comments[comment.ID] = comment
+	}
+	return nil
+}
+
+func (s *memoryStore) CreateFilterRule(rule *FilterRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rule.ID = s.id()
+	rule.CreatedAt = time.Now()
+	s.filters[rule.ID] = *rule
+	return nil
+}
+
+func (s *memoryStore) ListFilterRules(receiverID uint) ([]FilterRule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var rules []FilterRule
+	for _, rule := range s.filters {
+		if rule.ReceiverID == receiverID {
+			rules = append(rules, rule)
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+	return rules, nil
+}
+
+func (s *memoryStore) DeleteFilterRule(receiverID, id uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rule, ok := s.filters[id]
+	if !ok || rule.ReceiverID != receiverID {
+		return ErrNotFound
+	}
+	delete(s.filters, id)
+	return nil
+}
+
+func (s *memoryStore) CreateBlock(block *Block) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.blocks {
+		if existing.ReceiverID == block.ReceiverID && existing.BlockedID == block.BlockedID {
+			return ErrBlockExists
+		}
+	}
+
+	block.ID = s.id()
+	block.CreatedAt = time.Now()
+	s.blocks[block.ID] = *block
+	return nil
+}
+
+func (s *memoryStore) IsBlocked(receiverID, userID uint) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, block := range s.blocks {
+		if block.ReceiverID == receiverID && block.BlockedID == userID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *memoryStore) ListBlocks(receiverID uint) ([]Block, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var blocks []Block
+	for _, block := range s.blocks {
+		if block.ReceiverID == receiverID {
+			blocks = append(blocks, block)
+		}
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].ID < blocks[j].ID })
+	return blocks, nil
+}
+
+func (s *memoryStore) DeleteBlock(receiverID, blockedID uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, block := range s.blocks {
+		if block.ReceiverID == receiverID && block.BlockedID == blockedID {
+			delete(s.blocks, id)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (s *memoryStore) CreateModerationAction(action *ModerationAction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	action.ID = s.id()
+	action.CreatedAt = time.Now()
+	s.moderation = append(s.moderation, *action)
+	return nil
+}
+
+func (s *memoryStore) ListModerationActions(receiverID uint, limit int) ([]ModerationAction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var actions []ModerationAction
+	for i := len(s.moderation) - 1; i >= 0 && len(actions) < limit; i-- {
+		if s.moderation[i].ReceiverID == receiverID {
+			actions = append(actions, s.moderation[i])
+		}
+	}
+	return actions, nil
+}
+
+func (s *memoryStore) CreateUserSession(session *UserSession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session.ID = s.id()
+	s.sessions[session.ID] = *session
+	return nil
+}
+
+func (s *memoryStore) FindUserSession(tokenHash string) (UserSession, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, session := range s.sessions {
+		if session.TokenHash == tokenHash {
+			return session, nil
+		}
+	}
+	return UserSession{}, ErrNotFound
+}
+
+func (s *memoryStore) ListUserSessions(userID uint, now time.Time) ([]UserSession, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var sessions []UserSession
+	for _, session := range s.sessions {
+		if session.UserID == userID && session.ExpiresAt.After(now) {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt) })
+	return sessions, nil
+}
+
+func (s *memoryStore) TouchUserSession(id uint, seenAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	session.LastSeenAt = seenAt
+	s.sessions[id] = session
+	return nil
+}
+
+func (s *memoryStore) DeleteUserSession(userID, id uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok || session.UserID != userID {
+		return ErrNotFound
+	}
+	delete(s.sessions, id)
+	return nil
+}
+
+func (s *memoryStore) DeleteUserSessions(userID uint) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	for id, session := range s.sessions {
+		if session.UserID == userID {
+			delete(s.sessions, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (s *memoryStore) DeleteExpiredUserSessions(now time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	for id, session := range s.sessions {
+		if !session.ExpiresAt.After(now) {
+			delete(s.sessions, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (s *memoryStore) Migrate() error {
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,780 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testStores returns a constructor for every backend the store tests run
+// against. Memory and SQLite always run; PostgreSQL and MySQL run when
+// TEST_POSTGRES_HOST or TEST_MYSQL_HOST point at a scratch database, whose
+// tables are dropped and recreated by every test.
+func testStores() map[string]func(t *testing.T) Store {
+	stores := map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store {
+			return newMemoryStore()
+		},
+		"sqlite": func(t *testing.T) Store {
+			return openTestGormStore(t, DatabaseConfig{Driver: "sqlite", Name: filepath.Join(t.TempDir(), "test.db")})
+		},
+	}
+	for driver, prefix := range map[string]string{"postgres": "TEST_POSTGRES", "mysql": "TEST_MYSQL"} {
+		cfg, ok := externalTestDatabase(driver, prefix)
+		if !ok {
+			continue
+		}
+		stores[driver] = func(t *testing.T) Store {
+			return openTestGormStore(t, cfg)
+		}
+	}
+	return stores
+}
+
+func externalTestDatabase(driver, prefix string) (DatabaseConfig, bool) {
+	host := os.Getenv(prefix + "_HOST")
+	if host == "" {
+		return DatabaseConfig{}, false
+	}
+	return DatabaseConfig{
+		Driver:   driver,
+		Host:     host,
+		Port:     os.Getenv(prefix + "_PORT"),
+		User:     os.Getenv(prefix + "_USER"),
+		Password: os.Getenv(prefix + "_PASSWORD"),
+		Name:     os.Getenv(prefix + "_DATABASE"),
+		SSLMode:  "disable",
+	}, true
+}
+
+func openTestGormStore(t testing.TB, cfg DatabaseConfig) *gormStore {
+	t.Helper()
+
+	s, err := openGormStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs := s.(*gormStore)
+	gs.db = gs.db.Session(&gorm.Session{Logger: logger.Default.LogMode(logger.Silent)})
+	t.Cleanup(func() { gs.Close() })
+
+	if err := gs.MigrateDown(len(migrations)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gs.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	return gs
+}
+
+// runStoreTests runs test once against each backend, so that they are held
+// to the same behavior.
+func runStoreTests(t *testing.T, test func(t *testing.T, s Store)) {
+	for name, open := range testStores() {
+		t.Run(name, func(t *testing.T) {
+			test(t, open(t))
+		})
+	}
+}
+
+func mustSaveUser(t testing.TB, s Store, githubID float64, login string) GitHubUser {
+	t.Helper()
+
+	user, err := s.SaveUser(githubID, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func mustCreateComment(t testing.TB, s Store, comment Comment) Comment {
+	t.Helper()
+
+	if err := s.CreateComment(&comment); err != nil {
+		t.Fatal(err)
+	}
+	return comment
+}
+
+func mustFindComment(t *testing.T, s Store, id uint) Comment {
+	t.Helper()
+
+	comment, err := s.FindComment(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return comment
+}
+
+func wantErr(t *testing.T, what string, got, want error) {
+	t.Helper()
+
+	if !errors.Is(got, want) {
+		t.Errorf("%s: error = %v, want %v", what, got, want)
+	}
+}
+
+func TestStoreUsers(t *testing.T) {
+	runStoreTests(t, func(t *testing.T, s Store) {
+		alice := mustSaveUser(t, s, 100, "alice")
+		bob := mustSaveUser(t, s, 200, "bob")
+
+		renamed := mustSaveUser(t, s, 100, "alice2")
+		if renamed.ID != alice.ID || renamed.GitHubLogin != "alice2" {
+			t.Errorf("SaveUser of an existing GitHub ID = %+v, want ID %d renamed", renamed, alice.ID)
+		}
+
+		if user, err := s.FindUserByLogin("alice2"); err != nil || user.ID != alice.ID {
+			t.Errorf("FindUserByLogin = %+v, %v", user, err)
+		}
+		if user, err := s.FindUserByLogin("ALICE2"); err != nil || user.ID != alice.ID || user.GitHubLogin != "alice2" {
+			t.Errorf("FindUserByLogin with different case = %+v, %v", user, err)
+		}
+		if user, err := s.FindUserByGitHubID(200); err != nil || user.ID != bob.ID {
+			t.Errorf("FindUserByGitHubID = %+v, %v", user, err)
+		}
+		if user, err := s.FindUser(bob.ID); err != nil || user.GitHubLogin != "bob" {
+			t.Errorf("FindUser = %+v, %v", user, err)
+		}
+
+		_, err := s.FindUserByLogin("alice")
+		wantErr(t, "FindUserByLogin of an old login", err, ErrNotFound)
+		_, err = s.FindUserByLogin("")
+		wantErr(t, "FindUserByLogin(\"\")", err, ErrNotFound)
+		_, err = s.FindUserByGitHubID(0)
+		wantErr(t, "FindUserByGitHubID(0)", err, ErrNotFound)
+		_, err = s.FindUser(0)
+		wantErr(t, "FindUser(0)", err, ErrNotFound)
+
+		users, err := s.ListUsers()
+		if err != nil || len(users) != 2 || users[0].ID != alice.ID || users[1].ID != bob.ID {
+			t.Errorf("ListUsers = %+v, %v", users, err)
+		}
+
+		touched, err := s.TouchBoard(alice.ID)
+		if err != nil || touched.BoardRevision != alice.BoardRevision+1 {
+			t.Errorf("TouchBoard = %+v, %v", touched, err)
+		}
+		_, err = s.TouchBoard(0)
+		wantErr(t, "TouchBoard(0)", err, ErrNotFound)
+
+		if err := s.SetRequireApproval(bob.ID, true); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.SetRequireApproval(bob.ID, true); err != nil {
+			t.Errorf("SetRequireApproval to the current value: %v", err)
+		}
+		if user, _ := s.FindUser(bob.ID); !user.RequireApproval {
+			t.Error("SetRequireApproval was not saved")
+		}
+		wantErr(t, "SetRequireApproval of a missing user", s.SetRequireApproval(0, true), ErrNotFound)
+	})
+}
+
+func TestStoreComments(t *testing.T) {
+	runStoreTests(t, func(t *testing.T, s Store) {
+		owner := mustSaveUser(t, s, 1, "owner")
+		author := mustSaveUser(t, s, 2, "author")
+
+		comment := mustCreateComment(t, s, Comment{ReceiverID: owner.ID, AuthorID: author.ID, Content: "hello"})
+		if comment.ID == 0 || comment.CreatedAt.IsZero() {
+			t.Fatalf("CreateComment left %+v without an ID or timestamp", comment)
+		}
+		err := s.CreateComment(&Comment{ReceiverID: owner.ID, AuthorID: author.ID, Content: "again"})
+		wantErr(t, "CreateComment twice", err, ErrCommentExists)
+
+		reply := mustCreateComment(t, s, Comment{ReceiverID: owner.ID, AuthorID: owner.ID, ParentID: comment.ID, Content: "reply"})
+
+		if found := mustFindComment(t, s, comment.ID); found.Content != "hello" || found.AuthorID != author.ID {
+			t.Errorf("FindComment = %+v", found)
+		}
+		_, err = s.FindComment(0)
+		wantErr(t, "FindComment(0)", err, ErrNotFound)
+		_, err = s.FindComment(reply.ID + 100)
+		wantErr(t, "FindComment of a missing ID", err, ErrNotFound)
+
+		if found, err := s.FindCommentByAuthor(owner.ID, owner.ID, comment.ID); err != nil || found.ID != reply.ID {
+			t.Errorf("FindCommentByAuthor = %+v, %v", found, err)
+		}
+		_, err = s.FindCommentByAuthor(owner.ID, owner.ID, 0)
+		wantErr(t, "FindCommentByAuthor without a top-level comment", err, ErrNotFound)
+
+		views, err := s.ListCommentViews(owner.ID, 0)
+		if err != nil || len(views) != 2 {
+			t.Fatalf("ListCommentViews = %+v, %v", views, err)
+		}
+		if views[0].ID != comment.ID || views[0].Author != "author" || views[1].ParentID != comment.ID || views[0].Edited() {
+			t.Errorf("ListCommentViews = %+v", views)
+		}
+		if views, _ := s.ListCommentViews(author.ID, 0); len(views) != 0 {
+			t.Errorf("ListCommentViews of an empty board = %+v", views)
+		}
+
+		for _, set := range []struct {
+			name string
+			set  func(id uint, on bool) error
+		}{
+			{"SetOwnerLiked", s.SetOwnerLiked},
+			{"SetCommentHidden", s.SetCommentHidden},
+			{"SetCommentPending", s.SetCommentPending},
+		} {
+			if err := set.set(comment.ID, true); err != nil {
+				t.Errorf("%s: %v", set.name, err)
+			}
+			if err := set.set(comment.ID, true); err != nil {
+				t.Errorf("%s to the current value: %v", set.name, err)
+			}
+			wantErr(t, set.name+" of a missing comment", set.set(0, true), ErrNotFound)
+		}
+		found := mustFindComment(t, s, comment.ID)
+		if !found.IsOwnerLiked || !found.Hidden || !found.Pending {
+			t.Errorf("flags were not saved: %+v", found)
+		}
+		views, _ = s.ListCommentViews(owner.ID, 0)
+		if views[0].Edited() {
+			t.Error("setting flags marked the comment as edited")
+		}
+
+		if err := s.SetCommentPending(comment.ID, false); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+		if err := s.UpdateCommentContent(comment.ID, "edited", false); err != nil {
+			t.Fatal(err)
+		}
+		views, _ = s.ListCommentViews(owner.ID, 0)
+		if views[0].Content != "edited" || !views[0].Edited() || views[0].Pending {
+			t.Errorf("after UpdateCommentContent = %+v", views[0])
+		}
+		if err := s.UpdateCommentContent(comment.ID, "queued", true); err != nil {
+			t.Fatal(err)
+		}
+		if found := mustFindComment(t, s, comment.ID); found.Content != "queued" || !found.Pending {
+			t.Errorf("after UpdateCommentContent into the queue = %+v", found)
+		}
+		if err := s.UpdateCommentContent(comment.ID, "still queued", false); err != nil {
+			t.Fatal(err)
+		}
+		if found := mustFindComment(t, s, comment.ID); !found.Pending {
+			t.Error("UpdateCommentContent without pending approved the comment")
+		}
+		wantErr(t, "UpdateCommentContent of a missing comment", s.UpdateCommentContent(0, "x", false), ErrNotFound)
+	})
+}
+
+func TestStoreDeleteComment(t *testing.T) {
+	runStoreTests(t, func(t *testing.T, s Store) {
+		owner := mustSaveUser(t, s, 1, "owner")
+		author := mustSaveUser(t, s, 2, "author")
+		viewer := mustSaveUser(t, s, 3, "viewer")
+
+		comment := mustCreateComment(t, s, Comment{ReceiverID: owner.ID, AuthorID: author.ID, Content: "hello"})
+		reply := mustCreateComment(t, s, Comment{ReceiverID: owner.ID, AuthorID: owner.ID, ParentID: comment.ID, Content: "reply"})
+		other := mustCreateComment(t, s, Comment{ReceiverID: owner.ID, AuthorID: viewer.ID, Content: "other"})
+
+		if err := s.AddLike(reply.ID, viewer.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.CreateReport(&Report{CommentID: comment.ID, ReporterID: viewer.ID, Reason: "spam"}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.DeleteComment(comment.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.DeleteComment(comment.ID); err != nil {
+			t.Errorf("DeleteComment of a deleted comment: %v", err)
+		}
+
+		_, err := s.FindComment(reply.ID)
+		wantErr(t, "FindComment of a deleted reply", err, ErrNotFound)
+		if liked, _ := s.HasLiked(reply.ID, viewer.ID); liked {
+			t.Error("DeleteComment kept a like on a reply")
+		}
+		if reports, _ := s.ListOpenReports(10); len(reports) != 0 {
+			t.Errorf("DeleteComment kept reports: %+v", reports)
+		}
+		if views, _ := s.ListCommentViews(owner.ID, 0); len(views) != 1 || views[0].ID != other.ID {
+			t.Errorf("ListCommentViews after delete = %+v", views)
+		}
+	})
+}
+
+func TestStoreReactions(t *testing.T) {
+	runStoreTests(t, func(t *testing.T, s Store) {
+		owner := mustSaveUser(t, s, 1, "owner")
+		viewer := mustSaveUser(t, s, 2, "viewer")
+		other := mustSaveUser(t, s, 3, "other")
+		comment := mustCreateComment(t, s, Comment{ReceiverID: owner.ID, AuthorID: owner.ID, Content: "hello"})
+
+		if err := s.AddLike(comment.ID, viewer.ID); err != nil {
+			t.Fatal(err)
+		}
+		wantErr(t, "AddLike twice", s.AddLike(comment.ID, viewer.ID), ErrReactionExists)
+		wantErr(t, "AddDislike after AddLike", s.AddDislike(comment.ID, viewer.ID), ErrReactionConflict)
+		if err := s.AddDislike(comment.ID, other.ID); err != nil {
+			t.Fatal(err)
+		}
+		wantErr(t, "AddLike on a missing comment", s.AddLike(comment.ID+100, viewer.ID), ErrNotFound)
+		wantErr(t, "AddDislike on a missing comment", s.AddDislike(comment.ID+100, viewer.ID), ErrNotFound)
+
+		if liked, err := s.HasLiked(comment.ID, viewer.ID); err != nil || !liked {
+			t.Errorf("HasLiked = %v, %v", liked, err)
+		}
+		if liked, _ := s.HasLiked(comment.ID, 0); liked {
+			t.Error("HasLiked(0) = true")
+		}
+		if disliked, err := s.HasDisliked(comment.ID, other.ID); err != nil || !disliked {
+			t.Errorf("HasDisliked = %v, %v", disliked, err)
+		}
+
+		views, _ := s.ListCommentViews(owner.ID, viewer.ID)
+		if views[0].Likes != 1 || views[0].Dislikes != 1 || !views[0].IsLiked || views[0].IsDisliked {
+			t.Errorf("ListCommentViews for the liker = %+v", views[0])
+		}
+		views, _ = s.ListCommentViews(owner.ID, other.ID)
+		if views[0].IsLiked || !views[0].IsDisliked {
+			t.Errorf("ListCommentViews for the disliker = %+v", views[0])
+		}
+
+		if err := s.RemoveLike(comment.ID, 0); err != nil {
+			t.Errorf("RemoveLike(0): %v", err)
+		}
+		if err := s.RemoveLike(comment.ID, other.ID); err != nil {
+			t.Errorf("RemoveLike without a like: %v", err)
+		}
+		if err := s.RemoveLike(comment.ID+100, viewer.ID); err != nil {
+			t.Errorf("RemoveLike on a missing comment: %v", err)
+		}
+		if found := mustFindComment(t, s, comment.ID); found.LikeCount != 1 {
+			t.Errorf("LikeCount after no-op removals = %d, want 1", found.LikeCount)
+		}
+
+		if err := s.RemoveLike(comment.ID, viewer.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.RemoveDislike(comment.ID, other.ID); err != nil {
+			t.Fatal(err)
+		}
+		wantErr(t, "AddLike after removing a dislike", s.AddLike(comment.ID, other.ID), nil)
+		wantErr(t, "RemoveLike", s.RemoveLike(comment.ID, other.ID), nil)
+		if found := mustFindComment(t, s, comment.ID); found.LikeCount != 0 || found.DislikeCount != 0 {
+			t.Errorf("counts after removals = %d, %d", found.LikeCount, found.DislikeCount)
+		}
+
+		if repaired, err := s.RecountReactions(); err != nil || repaired != 0 {
+			t.Errorf("RecountReactions of consistent counts = %d, %v", repaired, err)
+		}
+	})
+}
+
+func TestStorePins(t *testing.T) {
+	runStoreTests(t, func(t *testing.T, s Store) {
+		owner := mustSaveUser(t, s, 1, "owner")
+		a := mustSaveUser(t, s, 2, "a")
+		b := mustSaveUser(t, s, 3, "b")
+		first := mustCreateComment(t, s, Comment{ReceiverID: owner.ID, AuthorID: a.ID, Content: "first"})
+		second := mustCreateComment(t, s, Comment{ReceiverID: owner.ID, AuthorID: b.ID, Content: "second"})
+		elsewhere := mustCreateComment(t, s, Comment{ReceiverID: a.ID, AuthorID: b.ID, Content: "elsewhere"})
+
+		if err := s.SetPinnedComments(owner.ID, []uint{second.ID, first.ID, elsewhere.ID}); err != nil {
+			t.Fatal(err)
+		}
+		ids, err := s.PinnedCommentIDs(owner.ID)
+		if err != nil || len(ids) != 2 || ids[0] != second.ID || ids[1] != first.ID {
+			t.Errorf("PinnedCommentIDs = %v, %v", ids, err)
+		}
+		if found := mustFindComment(t, s, elsewhere.ID); found.PinnedPosition != 0 {
+			t.Error("SetPinnedComments pinned a comment on another board")
+		}
+
+		if err := s.SetPinnedComments(owner.ID, []uint{first.ID}); err != nil {
+			t.Fatal(err)
+		}
+		if ids, _ := s.PinnedCommentIDs(owner.ID); len(ids) != 1 || ids[0] != first.ID {
+			t.Errorf("PinnedCommentIDs after repinning = %v", ids)
+		}
+	})
+}
+
+func TestStoreAPITokens(t *testing.T) {
+	runStoreTests(t, func(t *testing.T, s Store) {
+		alice := mustSaveUser(t, s, 1, "alice")
+		bob := mustSaveUser(t, s, 2, "bob")
+
+		var tokens []APIToken
+		for _, hash := range []string{"a", "b", "c"} {
+			token := APIToken{UserID: alice.ID, Name: hash, TokenHash: hash, Scopes: scopeRead}
+			if err := s.CreateAPIToken(&token); err != nil {
+				t.Fatal(err)
+			}
+			tokens = append(tokens, token)
+		}
+		bobToken := APIToken{UserID: bob.ID, Name: "d", TokenHash: "d", Scopes: scopeRead}
+		if err := s.CreateAPIToken(&bobToken); err != nil {
+			t.Fatal(err)
+		}
+
+		if found, err := s.FindAPIToken("b"); err != nil || found.ID != tokens[1].ID {
+			t.Errorf("FindAPIToken = %+v, %v", found, err)
+		}
+		_, err := s.FindAPIToken("")
+		wantErr(t, "FindAPIToken(\"\")", err, ErrNotFound)
+
+		usedAt := time.Now().Truncate(time.Second)
+		if err := s.TouchAPIToken(tokens[0].ID, usedAt); err != nil {
+			t.Fatal(err)
+		}
+		wantErr(t, "TouchAPIToken of a missing token", s.TouchAPIToken(0, usedAt), ErrNotFound)
+
+		wantErr(t, "DeleteAPIToken(0)", s.DeleteAPIToken(alice.ID, 0), ErrNotFound)
+		wantErr(t, "DeleteAPIToken of another user's token", s.DeleteAPIToken(alice.ID, bobToken.ID), ErrNotFound)
+		if err := s.DeleteAPIToken(alice.ID, tokens[2].ID); err != nil {
+			t.Fatal(err)
+		}
+
+		listed, err := s.ListAPITokens(alice.ID)
+		if err != nil || len(listed) != 2 || listed[0].ID != tokens[0].ID || listed[1].ID != tokens[1].ID {
+			t.Fatalf("ListAPITokens = %+v, %v", listed, err)
+		}
+		if listed[0].LastUsedAt == nil || !listed[0].LastUsedAt.Equal(usedAt) {
+			t.Errorf("LastUsedAt = %v, want %v", listed[0].LastUsedAt, usedAt)
+		}
+		if listed, _ := s.ListAPITokens(0); len(listed) != 0 {
+			t.Errorf("ListAPITokens(0) = %+v", listed)
+		}
+	})
+}
+
+func TestStoreUserSessions(t *testing.T) {
+	runStoreTests(t, func(t *testing.T, s Store) {
+		alice := mustSaveUser(t, s, 1, "alice")
+		bob := mustSaveUser(t, s, 2, "bob")
+		now := time.Now().Truncate(time.Second)
+
+		newSession := func(user GitHubUser, hash string, lastSeen, expires time.Time) UserSession {
+			t.Helper()
+			session := UserSession{UserID: user.ID, TokenHash: hash, LastSeenAt: lastSeen, ExpiresAt: expires}
+			if err := s.CreateUserSession(&session); err != nil {
+				t.Fatal(err)
+			}
+			return session
+		}
+		older := newSession(alice, "a", now.Add(-time.Hour), now.Add(time.Hour))
+		newer := newSession(alice, "b", now.Add(-time.Minute), now.Add(time.Hour))
+		expired := newSession(alice, "c", now.Add(-2*time.Hour), now.Add(-time.Minute))
+		bobSession := newSession(bob, "d", now, now.Add(time.Hour))
+
+		if found, err := s.FindUserSession("a"); err != nil || found.ID != older.ID {
+			t.Errorf("FindUserSession = %+v, %v", found, err)
+		}
+		_, err := s.FindUserSession("")
+		wantErr(t, "FindUserSession(\"\")", err, ErrNotFound)
+
+		listed, err := s.ListUserSessions(alice.ID, now)
+		if err != nil || len(listed) != 2 || listed[0].ID != newer.ID || listed[1].ID != older.ID {
+			t.Errorf("ListUserSessions = %+v, %v", listed, err)
+		}
+
+		if err := s.TouchUserSession(older.ID, now); err != nil {
+			t.Fatal(err)
+		}
+		wantErr(t, "TouchUserSession of a missing session", s.TouchUserSession(0, now), ErrNotFound)
+		if listed, _ := s.ListUserSessions(alice.ID, now); len(listed) != 2 || listed[0].ID != older.ID {
+			t.Errorf("ListUserSessions after touch = %+v", listed)
+		}
+
+		wantErr(t, "DeleteUserSession(0)", s.DeleteUserSession(alice.ID, 0), ErrNotFound)
+		wantErr(t, "DeleteUserSession of another user's session", s.DeleteUserSession(alice.ID, bobSession.ID), ErrNotFound)
+		if listed, _ := s.ListUserSessions(alice.ID, now); len(listed) != 2 {
+			t.Errorf("failed deletes removed sessions: %+v", listed)
+		}
+
+		if deleted, err := s.DeleteExpiredUserSessions(now); err != nil || deleted != 1 {
+			t.Errorf("DeleteExpiredUserSessions = %d, %v", deleted, err)
+		}
+		_, err = s.FindUserSession(expired.TokenHash)
+		wantErr(t, "FindUserSession of an expired session", err, ErrNotFound)
+
+		if err := s.DeleteUserSession(alice.ID, newer.ID); err != nil {
+			t.Fatal(err)
+		}
+		if deleted, err := s.DeleteUserSessions(alice.ID); err != nil || deleted != 1 {
+			t.Errorf("DeleteUserSessions = %d, %v", deleted, err)
+		}
+		if deleted, _ := s.DeleteUserSessions(0); deleted != 0 {
+			t.Errorf("DeleteUserSessions(0) deleted %d sessions", deleted)
+		}
+		if _, err := s.FindUserSession(bobSession.TokenHash); err != nil {
+			t.Errorf("another user's session was deleted: %v", err)
+		}
+	})
+}
+
+func TestStoreReports(t *testing.T) {
+	runStoreTests(t, func(t *testing.T, s Store) {
+		owner := mustSaveUser(t, s, 1, "owner")
+		a := mustSaveUser(t, s, 2, "a")
+		b := mustSaveUser(t, s, 3, "b")
+		comment := mustCreateComment(t, s, Comment{ReceiverID: owner.ID, AuthorID: owner.ID, Content: "hello"})
+
+		first := Report{CommentID: comment.ID, ReporterID: a.ID, Reason: "spam"}
+		if count, err := s.CreateReport(&first); err != nil || count != 1 {
+			t.Fatalf("CreateReport = %d, %v, want a count of 1", count, err)
+		}
+		if first.ID == 0 || first.Status != reportOpen {
+			t.Errorf("CreateReport left %+v", first)
+		}
+		_, err := s.CreateReport(&Report{CommentID: comment.ID, ReporterID: a.ID, Reason: "other"})
+		wantErr(t, "CreateReport twice", err, ErrReportExists)
+		_, err = s.CreateReport(&Report{CommentID: comment.ID + 100, ReporterID: a.ID, Reason: "spam"})
+		wantErr(t, "CreateReport on a missing comment", err, ErrNotFound)
+		second := Report{CommentID: comment.ID, ReporterID: b.ID, Reason: "hate"}
+		if count, err := s.CreateReport(&second); err != nil || count != 2 {
+			t.Fatalf("CreateReport = %d, %v, want a count of 2", count, err)
+		}
+		if found := mustFindComment(t, s, comment.ID); found.ReportCount != 2 {
+			t.Errorf("ReportCount = %d, want 2", found.ReportCount)
+		}
+
+		if found, err := s.FindReport(second.ID); err != nil || found.ReporterID != b.ID || found.Reason != "hate" {
+			t.Errorf("FindReport = %+v, %v", found, err)
+		}
+		_, err = s.FindReport(0)
+		wantErr(t, "FindReport(0)", err, ErrNotFound)
+
+		if reports, err := s.ListOpenReports(1); err != nil || len(reports) != 1 || reports[0].ID != first.ID {
+			t.Errorf("ListOpenReports(1) = %+v, %v", reports, err)
+		}
+
+		wantErr(t, "DismissReport(0)", s.DismissReport(0), ErrNotFound)
+		if err := s.DismissReport(first.ID); err != nil {
+			t.Fatal(err)
+		}
+		wantErr(t, "DismissReport twice", s.DismissReport(first.ID), ErrNotFound)
+		if found := mustFindComment(t, s, comment.ID); found.ReportCount != 1 {
+			t.Errorf("ReportCount after dismissal = %d, want 1", found.ReportCount)
+		}
+		if reports, _ := s.ListOpenReports(10); len(reports) != 1 || reports[0].ID != second.ID {
+			t.Errorf("ListOpenReports after dismissal = %+v", reports)
+		}
+	})
+}
+
+func TestStoreFilterRules(t *testing.T) {
+	runStoreTests(t, func(t *testing.T, s Store) {
+		alice := mustSaveUser(t, s, 1, "alice")
+		bob := mustSaveUser(t, s, 2, "bob")
+
+		var rules []FilterRule
+		for _, pattern := range []string{"a", "b", "c"} {
+			rule := FilterRule{ReceiverID: alice.ID, Pattern: pattern, Action: filterMask}
+			if err := s.CreateFilterRule(&rule); err != nil {
+				t.Fatal(err)
+			}
+			rules = append(rules, rule)
+		}
+		bobRule := FilterRule{ReceiverID: bob.ID, Pattern: "d", Action: filterReject}
+		if err := s.CreateFilterRule(&bobRule); err != nil {
+			t.Fatal(err)
+		}
+
+		wantErr(t, "DeleteFilterRule(0)", s.DeleteFilterRule(alice.ID, 0), ErrNotFound)
+		wantErr(t, "DeleteFilterRule of another board's rule", s.DeleteFilterRule(alice.ID, bobRule.ID), ErrNotFound)
+		if err := s.DeleteFilterRule(alice.ID, rules[1].ID); err != nil {
+			t.Fatal(err)
+		}
+
+		listed, err := s.ListFilterRules(alice.ID)
+		if err != nil || len(listed) != 2 || listed[0].Pattern != "a" || listed[1].Pattern != "c" {
+			t.Errorf("ListFilterRules = %+v, %v", listed, err)
+		}
+		if listed, _ := s.ListFilterRules(bob.ID); len(listed) != 1 {
+			t.Errorf("ListFilterRules of another board = %+v", listed)
+		}
+	})
+}
+
+func TestStoreBlocks(t *testing.T) {
+	runStoreTests(t, func(t *testing.T, s Store) {
+		owner := mustSaveUser(t, s, 1, "owner")
+		a := mustSaveUser(t, s, 2, "a")
+		b := mustSaveUser(t, s, 3, "b")
+
+		for _, user := range []GitHubUser{a, b} {
+			if err := s.CreateBlock(&Block{ReceiverID: owner.ID, BlockedID: user.ID}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		wantErr(t, "CreateBlock twice", s.CreateBlock(&Block{ReceiverID: owner.ID, BlockedID: a.ID}), ErrBlockExists)
+
+		if blocked, err := s.IsBlocked(owner.ID, a.ID); err != nil || !blocked {
+			t.Errorf("IsBlocked = %v, %v", blocked, err)
+		}
+		if blocked, _ := s.IsBlocked(a.ID, owner.ID); blocked {
+			t.Error("IsBlocked is not directional")
+		}
+		if blocked, _ := s.IsBlocked(owner.ID, 0); blocked {
+			t.Error("IsBlocked(0) = true")
+		}
+
+		wantErr(t, "DeleteBlock(0)", s.DeleteBlock(owner.ID, 0), ErrNotFound)
+		if err := s.DeleteBlock(owner.ID, a.ID); err != nil {
+			t.Fatal(err)
+		}
+		wantErr(t, "DeleteBlock twice", s.DeleteBlock(owner.ID, a.ID), ErrNotFound)
+
+		blocks, err := s.ListBlocks(owner.ID)
+		if err != nil || len(blocks) != 1 || blocks[0].BlockedID != b.ID {
+			t.Errorf("ListBlocks = %+v, %v", blocks, err)
+		}
+	})
+}
+
+func TestStoreModerationActions(t *testing.T) {
+	runStoreTests(t, func(t *testing.T, s Store) {
+		alice := mustSaveUser(t, s, 1, "alice")
+		bob := mustSaveUser(t, s, 2, "bob")
+
+		for i, receiver := range []GitHubUser{alice, bob, alice, alice} {
+			action := ModerationAction{ReceiverID: receiver.ID, ModeratorID: receiver.ID, CommentID: uint(i + 1), Action: moderationHide}
+			if err := s.CreateModerationAction(&action); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		actions, err := s.ListModerationActions(alice.ID, 2)
+		if err != nil || len(actions) != 2 || actions[0].CommentID != 4 || actions[1].CommentID != 3 {
+			t.Errorf("ListModerationActions = %+v, %v", actions, err)
+		}
+		if actions, _ := s.ListModerationActions(0, 10); len(actions) != 0 {
+			t.Errorf("ListModerationActions(0) = %+v", actions)
+		}
+	})
+}
+
+// concurrently runs n calls of write at once and returns their errors.
+func concurrently(n int, write func(i int) error) []error {
+	errs := make([]error, n)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			errs[i] = write(i)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	return errs
+}
+
+// wantOneWinner checks that exactly one of errs is nil and that every other
+// write failed with one of the allowed errors.
+func wantOneWinner(t *testing.T, what string, errs []error, allowed ...error) {
+	t.Helper()
+
+	succeeded := 0
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+			continue
+		}
+		ok := false
+		for _, a := range allowed {
+			ok = ok || errors.Is(err, a)
+		}
+		if !ok {
+			t.Errorf("%s: unexpected error %v", what, err)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("%s: %d of %d concurrent writes succeeded, want 1", what, succeeded, len(errs))
+	}
+}
+
+func TestStoreConcurrentWrites(t *testing.T) {
+	const writers = 20
+
+	runStoreTests(t, func(t *testing.T, s Store) {
+		owner := mustSaveUser(t, s, 1, "owner")
+		author := mustSaveUser(t, s, 2, "author")
+		viewer := mustSaveUser(t, s, 3, "viewer")
+		other := mustSaveUser(t, s, 4, "other")
+
+		errs := concurrently(writers, func(i int) error {
+			return s.CreateComment(&Comment{ReceiverID: owner.ID, AuthorID: author.ID, Content: fmt.Sprint(i)})
+		})
+		wantOneWinner(t, "CreateComment", errs, ErrCommentExists)
+		views, err := s.ListCommentViews(owner.ID, 0)
+		if err != nil || len(views) != 1 {
+			t.Fatalf("ListCommentViews after concurrent CreateComment = %+v, %v", views, err)
+		}
+		commentID := views[0].ID
+
+		errs = concurrently(writers, func(int) error {
+			return s.AddLike(commentID, viewer.ID)
+		})
+		wantOneWinner(t, "AddLike", errs, ErrReactionExists)
+
+		errs = concurrently(writers, func(i int) error {
+			if i%2 == 0 {
+				return s.AddLike(commentID, other.ID)
+			}
+			return s.AddDislike(commentID, other.ID)
+		})
+		wantOneWinner(t, "AddLike and AddDislike", errs, ErrReactionExists, ErrReactionConflict)
+
+		liked, _ := s.HasLiked(commentID, other.ID)
+		disliked, _ := s.HasDisliked(commentID, other.ID)
+		if liked == disliked {
+			t.Errorf("after racing a like and a dislike: liked %v, disliked %v, want exactly one", liked, disliked)
+		}
+
+		// Every concurrent report must see a different count so that
+		// exactly one of them crosses the hide threshold.
+		reporters := make([]GitHubUser, writers)
+		for i := range reporters {
+			reporters[i] = mustSaveUser(t, s, float64(100+i), fmt.Sprint("reporter", i))
+		}
+		counts := make([]int, writers)
+		errs = concurrently(writers, func(i int) error {
+			var err error
+			counts[i], err = s.CreateReport(&Report{CommentID: commentID, ReporterID: reporters[i].ID, Reason: "spam"})
+			return err
+		})
+		seen := make(map[int]bool)
+		for i, err := range errs {
+			if err != nil {
+				t.Errorf("concurrent CreateReport: %v", err)
+			}
+			seen[counts[i]] = true
+		}
+		for count := 1; count <= writers; count++ {
+			if !seen[count] {
+				t.Errorf("no concurrent CreateReport returned a count of %d; got %v", count, counts)
+				break
+			}
+		}
+
+		comment := mustFindComment(t, s, commentID)
+		if comment.ReportCount != writers {
+			t.Errorf("ReportCount = %d, want %d", comment.ReportCount, writers)
+		}
+		if comment.LikeCount+comment.DislikeCount != 2 {
+			t.Errorf("counters = %d likes, %d dislikes, want 2 reactions", comment.LikeCount, comment.DislikeCount)
+		}
+		if repaired, err := s.RecountReactions(); err != nil || repaired != 0 {
+			t.Errorf("RecountReactions after concurrent writes = %d, %v", repaired, err)
+		}
+	})
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// svgCache holds rendered SVG cards per receiver so that repeated image proxy
+// fetches do not hit the database. Entries are grouped by username so that a
+// write to a board drops every rendering of it at once. Usernames are keyed
+// in lower case because FindUserByLogin ignores case, so every spelling of a
+// login resolves to the same board.
+type svgCache struct {
+	mu          sync.Mutex
+	ttl         time.Duration
+	boards      map[string]map[string]svgCacheEntry
+	generations map[string]uint64
+	renders     singleflight.Group
+}
+
+// renderedSVG is a rendered card together with the ETag of the board
+// revision it was rendered from.
+type renderedSVG struct {
+	Content string
+	ETag    string
+}
+
+type svgCacheEntry struct {
+	svg     renderedSVG
+	expires time.Time
+}
+
+func newSVGCache(ttl time.Duration) *svgCache {
+	return &svgCache{
+		ttl:         ttl,
+		boards:      make(map[string]map[string]svgCacheEntry),
+		generations: make(map[string]uint64),
+	}
+}
+
+// get returns the cached rendering for username and options, calling render
+// on a miss. Concurrent misses for the same key share a single render.
+func (sc *svgCache) get(username, options string, render func() (renderedSVG, error)) (renderedSVG, error) {
+	board := strings.ToLower(username)
+	if sc.ttl <= 0 {
+		return render()
+	}
+
+	sc.mu.Lock()
+	entry, ok := sc.boards[board][options]
+	generation := sc.generations[board]
+	sc.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.svg, nil
+	}
+
+	// The generation is part of the key so a render started before an
+	// invalidation is neither joined nor stored by requests that follow it.
+	key := fmt.Sprintf("%s\x00%d\x00%s", board, generation, options)
+	v, err, _ := sc.renders.Do(key, func() (interface{}, error) {
+		svg, err := render()
+		if err != nil {
+			return nil, err
+		}
+
+		sc.mu.Lock()
+		if sc.generations[board] == generation {
+			if sc.boards[board] == nil {
+				sc.boards[board] = make(map[string]svgCacheEntry)
+			}
+			sc.boards[board][options] = svgCacheEntry{svg: svg, expires: time.Now().Add(sc.ttl)}
+		}
+		sc.mu.Unlock()
+		return svg, nil
+	})
+	if err != nil {
+		return renderedSVG{}, err
+	}
+	return v.(renderedSVG), nil
+}
+
+// invalidate drops every cached rendering of username's board.
+func (sc *svgCache) invalidate(username string) {
+	board := strings.ToLower(username)
+
+	sc.mu.Lock()
+	delete(sc.boards, board)
+	sc.generations[board]++
+	sc.mu.Unlock()
+}
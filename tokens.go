@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	scopeRead     = "read"
+	scopeComment  = "comment"
+	scopeReact    = "react"
+	scopeModerate = "moderate"
+)
+
+var tokenScopes = []string{scopeRead, scopeComment, scopeReact, scopeModerate}
+
+const (
+	apiTokenPrefix = "gpc_"
+	// apiTokenTouchInterval limits how often LastUsedAt is written for a
+	// token that is used in a tight loop.
+	apiTokenTouchInterval = time.Minute
+)
+
+// APIToken is a personal access token. Only the SHA-256 of the secret is
+// stored; the secret itself is shown once when the token is created.
+type APIToken struct {
+	ID         uint `gorm:"primary_key"`
+	UserID     uint
+	Name       string
+	TokenHash  string
+	Scopes     string
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+func (t APIToken) HasScope(scope string) bool {
+	for _, s := range strings.Split(t.Scopes, ",") {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type APITokenResponse struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Token      string     `json:"token,omitempty"`
+}
+
+func newAPITokenResponse(t APIToken) APITokenResponse {
+	return APITokenResponse{
+		ID:         t.ID,
+		Name:       t.Name,
+		Scopes:     strings.Split(t.Scopes, ","),
+		CreatedAt:  t.CreatedAt,
+		LastUsedAt: t.LastUsedAt,
+	}
+}
+
+// hashSecret is how bearer secrets such as API tokens and session tokens are
+// stored and looked up.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateAPIToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return apiTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+}
+
+func createAPIToken(c *gin.Context) {
+	user := currentUser(c)
+
+	var req struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		c.JSON(400, gin.H{"error": "Token name must be 1 to 100 characters"})
+		return
+	}
+
+	var scopes []string
+	for _, scope := range tokenScopes {
+		for _, requested := range req.Scopes {
+			if requested == scope {
+				scopes = append(scopes, scope)
+				break
+			}
+		}
+	}
+	if len(scopes) == 0 || len(scopes) != len(req.Scopes) {
+		c.JSON(400, gin.H{"error": "Scopes must be a non-empty list of " + strings.Join(tokenScopes, ", ")})
+		return
+	}
+
+	secret := generateAPIToken()
+	token := APIToken{
+		UserID:    user.ID,
+		Name:      req.Name,
+		TokenHash: hashSecret(secret),
+		Scopes:    strings.Join(scopes, ","),
+	}
+	if err := store.CreateAPIToken(&token); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to create token"})
+		return
+	}
+
+	response := newAPITokenResponse(token)
+	response.Token = secret
+	c.JSON(201, response)
+}
+
+func listAPITokens(c *gin.Context) {
+	user := currentUser(c)
+
+	tokens, err := store.ListAPITokens(user.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to get tokens"})
+		return
+	}
+
+	responses := make([]APITokenResponse, 0, len(tokens))
+	for _, token := range tokens {
+		responses = append(responses, newAPITokenResponse(token))
+	}
+	c.JSON(200, responses)
+}
+
+func revokeAPIToken(c *gin.Context) {
+	user := currentUser(c)
+
+	tokenID, err := strconv.ParseUint(c.Param("tokenID"), 10, 64)
+	if err != nil || tokenID == 0 {
+		c.JSON(400, gin.H{"error": "Invalid token ID"})
+		return
+	}
+
+	if err := store.DeleteAPIToken(user.ID, uint(tokenID)); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			c.JSON(404, gin.H{"error": "Token not found"})
+		} else {
+			c.JSON(500, gin.H{"error": "Failed to revoke token"})
+		}
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Token revoked"})
+}
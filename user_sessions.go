@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// userSessionTouchInterval limits how often LastSeenAt is written for an
+// active session.
+const userSessionTouchInterval = time.Minute
+
+// UserSession is a server-side login. The session cookie only carries the
+// random token whose SHA-256 is stored here, so deleting the row signs the
+// browser out no matter who holds the cookie.
+type UserSession struct {
+	ID         uint `gorm:"primary_key"`
+	UserID     uint
+	TokenHash  string
+	UserAgent  string
+	IPAddress  string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+}
+
+type UserSessionResponse struct {
+	ID         uint      `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+// startUserSession records a new login for user and points the cookie
+// session at it.
+func startUserSession(c *gin.Context, user GitHubUser) error {
+	if _, err := store.DeleteExpiredUserSessions(time.Now()); err != nil {
+		fmt.Println("Error deleting expired sessions:", err)
+	}
+
+	// Logging in again replaces the login this browser already had instead
+	// of leaving it valid next to the new one.
+	session := sessions.Default(c)
+	if previous, ok := session.Get("session_token").(string); ok {
+		if userSession, err := store.FindUserSession(hashSecret(previous)); err == nil {
+			if err := store.DeleteUserSession(userSession.UserID, userSession.ID); err != nil && !errors.Is(err, ErrNotFound) {
+				fmt.Println("Error deleting previous session:", err)
+			}
+		}
+	}
+
+	token := generateStateString()
+	now := time.Now()
+	userSession := UserSession{
+		UserID:     user.ID,
+		TokenHash:  hashSecret(token),
+		UserAgent:  truncate(c.Request.UserAgent(), 255),
+		IPAddress:  c.ClientIP(),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(time.Duration(config.SessionTTL)),
+	}
+	if err := store.CreateUserSession(&userSession); err != nil {
+		return err
+	}
+
+	session.Set("session_token", token)
+	session.Set("csrf_token", generateStateString())
+	return session.Save()
+}
+
+// sessionPrincipal resolves the login referenced by the session cookie, if
+// it still exists and has not expired.
+func sessionPrincipal(c *gin.Context) *Principal {
+	token, ok := sessions.Default(c).Get("session_token").(string)
+	if !ok {
+		return nil
+	}
+
+	userSession, err := store.FindUserSession(hashSecret(token))
+	if err != nil {
+		return nil
+	}
+
+	now := time.Now()
+	if !now.Before(userSession.ExpiresAt) {
+		return nil
+	}
+	if now.Sub(userSession.LastSeenAt) > userSessionTouchInterval {
+		if err := store.TouchUserSession(userSession.ID, now); err != nil {
+			fmt.Println("Error updating session last use:", err)
+		}
+		userSession.LastSeenAt = now
+	}
+
+	user, err := store.FindUser(userSession.UserID)
+	if err != nil {
+		return nil
+	}
+	return &Principal{User: user, Session: &userSession}
+}
+
+func listUserSessions(c *gin.Context) {
+	principal := currentPrincipal(c)
+
+	userSessions, err := store.ListUserSessions(principal.User.ID, time.Now())
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to get sessions"})
+		return
+	}
+
+	responses := make([]UserSessionResponse, 0, len(userSessions))
+	for _, userSession := range userSessions {
+		responses = append(responses, UserSessionResponse{
+			ID:         userSession.ID,
+			UserAgent:  userSession.UserAgent,
+			IPAddress:  userSession.IPAddress,
+			CreatedAt:  userSession.CreatedAt,
+			LastSeenAt: userSession.LastSeenAt,
+			ExpiresAt:  userSession.ExpiresAt,
+			Current:    userSession.ID == principal.Session.ID,
+		})
+	}
+	c.JSON(200, responses)
+}
+
+func revokeUserSession(c *gin.Context) {
+	user := currentUser(c)
+
+	sessionID, err := strconv.ParseUint(c.Param("sessionID"), 10, 64)
+	if err != nil || sessionID == 0 {
+		c.JSON(400, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	if err := store.DeleteUserSession(user.ID, uint(sessionID)); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			c.JSON(404, gin.H{"error": "Session not found"})
+		} else {
+			c.JSON(500, gin.H{"error": "Failed to revoke session"})
+		}
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Session revoked"})
+}
+
+func logoutEverywhere(c *gin.Context) {
+	user := currentUser(c)
+
+	revoked, err := store.DeleteUserSessions(user.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+
+	session := sessions.Default(c)
+	session.Clear()
+	session.Save()
+
+	c.JSON(200, gin.H{"message": "Logged out everywhere", "revoked": revoked})
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
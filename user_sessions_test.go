@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func loggedIn(t *testing.T, router http.Handler, cookie *http.Cookie) bool {
+	t.Helper()
+
+	w := doCookieRequest(t, router, "GET", "/api/", cookie, "", nil)
+	wantStatus(t, w, 200)
+	var main struct {
+		LoggedIn bool `json:"logged_in"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &main); err != nil {
+		t.Fatal(err)
+	}
+	return main.LoggedIn
+}
+
+func TestStartUserSessionReplacesPreviousLogin(t *testing.T) {
+	router := newTestRouter(t)
+	user, _ := newTestUser(t, 1, "owner")
+	other, _ := newTestUser(t, 2, "other")
+
+	laptop := loginTestSession(t, user, nil)
+	phone := loginTestSession(t, user, nil)
+	relogin := loginTestSession(t, user, laptop)
+
+	if loggedIn(t, router, laptop) {
+		t.Error("the cookie from before logging in again is still signed in")
+	}
+	if !loggedIn(t, router, relogin) || !loggedIn(t, router, phone) {
+		t.Error("logging in again signed out the new login or another browser")
+	}
+	if userSessions, _ := store.ListUserSessions(user.ID, time.Now()); len(userSessions) != 2 {
+		t.Errorf("got %d sessions after logging in again, want 2: %+v", len(userSessions), userSessions)
+	}
+
+	// Switching accounts in the same browser ends the first account's login.
+	switched := loginTestSession(t, other, relogin)
+	if loggedIn(t, router, relogin) || !loggedIn(t, router, switched) {
+		t.Error("switching accounts left the previous login signed in")
+	}
+	if userSessions, _ := store.ListUserSessions(user.ID, time.Now()); len(userSessions) != 1 {
+		t.Errorf("got %d sessions after switching accounts, want 1", len(userSessions))
+	}
+}